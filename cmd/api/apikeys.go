@@ -0,0 +1,125 @@
+// Filename: cmd/api/apikeys.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Lee26Ed/qod/internal/data"
+	"github.com/Lee26Ed/qod/internal/validator"
+)
+
+// POST /v1/api-keys
+// create an API key for the logged in user. The key is only shown in
+// this response. Tiers above the default are handed out by admins
+func (a *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		Name string `json:"name"`
+		Tier string `json:"tier"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	if incomingData.Tier == "" {
+		incomingData.Tier = data.DefaultAPIKeyTier
+	}
+
+	user := a.contextGetUser(r)
+	key := data.NewAPIKey(user.ID, incomingData.Name, incomingData.Tier)
+
+	v := validator.New()
+	data.ValidateAPIKey(v, key)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if key.Tier != data.DefaultAPIKeyTier {
+		permissions, err := a.permissionModel.GetAllForUser(r.Context(), user.ID)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+		if !permissions.Include(data.PermissionQuotesAdmin) {
+			a.notPermittedResponse(w, r)
+			return
+		}
+	}
+
+	keys, err := a.apiKeyModel.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if len(keys) >= data.MaxAPIKeysPerUser {
+		v.AddError("api_key", fmt.Sprintf("you already have %d keys, revoke one first", data.MaxAPIKeysPerUser))
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.apiKeyModel.Insert(r.Context(), key)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"api_key": key,
+	}
+	err = a.writeJSON(w, http.StatusCreated, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// GET /v1/api-keys
+// the keys of the logged in user, without the keys themselves
+func (a *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := a.apiKeyModel.GetAllForUser(r.Context(), a.contextGetUser(r).ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"api_keys": keys,
+	}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// DELETE /v1/api-keys/:id
+// revoke one of the logged in user's keys
+func (a *application) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	err = a.apiKeyModel.Delete(r.Context(), id, a.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{
+		"message": "API key successfully revoked",
+	}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,161 @@
+// Filename: cmd/api/apikeys_internal_test.go
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Lee26Ed/qod/internal/data"
+)
+
+func TestAPIKeys(t *testing.T) {
+	app := newTestApplication(t)
+	// without a key the test's IP only gets two requests
+	app.config.limiter.enabled = true
+	app.config.limiter.rps = 0.01
+	app.config.limiter.burst = 2
+	handler := app.routes()
+	auth := newTestUser(t, app, "bot@example.com", data.PermissionQuotesWrite)
+
+	w, _ := sendRequest(t, handler, http.MethodPost, "/v1/api-keys", `{"name": "importer", "tier": "premium"}`, auth)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status %d for a tier above basic, got %d", http.StatusForbidden, w.Code)
+	}
+
+	w, body := sendRequest(t, handler, http.MethodPost, "/v1/api-keys", `{"name": "importer"}`, auth)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, w.Code)
+	}
+	created := body["api_key"].(map[string]any)
+	if created["tier"] != data.DefaultAPIKeyTier {
+		t.Errorf("expected tier %q, got %v", data.DefaultAPIKeyTier, created["tier"])
+	}
+	withKey := map[string]string{"X-API-Key": created["key"].(string)}
+
+	// the key's owner has their own bucket and the key writes as them
+	for range 3 {
+		w, _ = sendRequest(t, handler, http.MethodPost, "/v1/quotes",
+			`{"content": "Know thyself", "author": "Socrates"}`, withKey)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("expected status %d with the API key, got %d", http.StatusCreated, w.Code)
+		}
+	}
+	if got := w.Header().Get("RateLimit-Limit"); got != "20" {
+		t.Errorf("expected RateLimit-Limit 20 for the basic tier, got %q", got)
+	}
+	if got := w.Header().Get("RateLimit-Remaining"); got != "17" {
+		t.Errorf("expected RateLimit-Remaining 17, got %q", got)
+	}
+
+	_, body = sendRequest(t, handler, http.MethodGet, "/v1/api-keys", "", withKey)
+	keys := body["api_keys"].([]any)
+	if len(keys) != 1 || keys[0].(map[string]any)["key"] != nil {
+		t.Errorf("expected one key without its plaintext, got %v", keys)
+	}
+
+	w, _ = sendRequest(t, handler, http.MethodDelete, "/v1/api-keys/1", "", withKey)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	// a revoked key falls back to the IP, which used up its two requests
+	w, _ = sendRequest(t, handler, http.MethodGet, "/v1/quotes", "", withKey)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("expected a Retry-After header")
+	}
+}
+
+func TestAPIKeysPerUser(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.limiter.enabled = true
+	app.config.limiter.rps = 0.01
+	app.config.limiter.burst = 100
+	handler := app.routes()
+	auth := newTestUser(t, app, "bot@example.com")
+
+	var keys []string
+	for range data.MaxAPIKeysPerUser {
+		w, body := sendRequest(t, handler, http.MethodPost, "/v1/api-keys", `{"name": "worker"}`, auth)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("expected status %d, got %d", http.StatusCreated, w.Code)
+		}
+		keys = append(keys, body["api_key"].(map[string]any)["key"].(string))
+	}
+
+	w, _ := sendRequest(t, handler, http.MethodPost, "/v1/api-keys", `{"name": "one too many"}`, auth)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status %d past %d keys, got %d", http.StatusUnprocessableEntity, data.MaxAPIKeysPerUser, w.Code)
+	}
+
+	// more keys don't mean more requests, they all take from one bucket
+	for i, remaining := range []string{"19", "18", "17"} {
+		w, _ := sendRequest(t, handler, http.MethodGet, "/v1/quotes", "", map[string]string{"X-API-Key": keys[i]})
+		if got := w.Header().Get("RateLimit-Remaining"); got != remaining {
+			t.Errorf("expected RateLimit-Remaining %s with key %d, got %q", remaining, i, got)
+		}
+	}
+}
+
+// an admin moving the keys to another tier in the database
+type retieredAPIKeys struct {
+	data.APIKeyStore
+	tier string
+}
+
+func (s *retieredAPIKeys) GetByPlaintext(ctx context.Context, plaintext string) (*data.APIKey, error) {
+	key, err := s.APIKeyStore.GetByPlaintext(ctx, plaintext)
+	if err == nil && s.tier != "" {
+		key.Tier = s.tier
+	}
+	return key, err
+}
+
+func TestAPIKeyNewTier(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.limiter.enabled = true
+	app.config.limiter.rps = 0.01
+	app.config.limiter.burst = 100
+	keys := &retieredAPIKeys{APIKeyStore: app.apiKeyModel}
+	app.apiKeyModel = keys
+	handler := app.routes()
+	auth := newTestUser(t, app, "bot@example.com")
+
+	_, body := sendRequest(t, handler, http.MethodPost, "/v1/api-keys", `{"name": "worker"}`, auth)
+	withKey := map[string]string{"X-API-Key": body["api_key"].(map[string]any)["key"].(string)}
+
+	// every tier the key is on has its own bucket at the tier's rate
+	for _, tt := range []struct {
+		tier      string
+		limit     string
+		remaining string
+	}{
+		{"basic", "20", "19"},
+		{"premium", "400", "399"},
+		{"premium", "400", "398"},
+		{"basic", "20", "18"},
+	} {
+		keys.tier = tt.tier
+		w, _ := sendRequest(t, handler, http.MethodGet, "/v1/quotes", "", withKey)
+		if got := w.Header().Get("RateLimit-Limit"); got != tt.limit {
+			t.Errorf("%s: expected RateLimit-Limit %s, got %q", tt.tier, tt.limit, got)
+		}
+		if got := w.Header().Get("RateLimit-Remaining"); got != tt.remaining {
+			t.Errorf("%s: expected RateLimit-Remaining %s, got %q", tt.tier, tt.remaining, got)
+		}
+	}
+}
+
+func TestRevokedAPIKey(t *testing.T) {
+	handler := newTestApplication(t).routes()
+
+	w, _ := sendRequest(t, handler, http.MethodGet, "/v1/quotes", "",
+		map[string]string{"X-API-Key": "qod_AAAAAAAAAAAAAAAAAAAAAAAAAA"})
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
@@ -0,0 +1,282 @@
+// Filename: cmd/api/authors.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Lee26Ed/qod/internal/data"
+	"github.com/Lee26Ed/qod/internal/validator"
+)
+
+// the values the author listing can be sorted by
+var authorSortSafelist = []string{"id", "-id", "name", "-name", "created_at", "-created_at"}
+
+// POST /v1/authors
+func (a *application) createAuthorHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		Name string `json:"name"`
+		Bio  string `json:"bio"`
+		Born string `json:"born"`
+		Died string `json:"died"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	author := &data.Author{
+		Name: incomingData.Name,
+		Bio:  incomingData.Bio,
+		Born: incomingData.Born,
+		Died: incomingData.Died,
+	}
+
+	v := validator.New()
+	data.ValidateAuthor(v, author)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.authorModel.Insert(r.Context(), author)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateAuthor):
+			v.AddError("name", "an author with this name already exists")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/authors/%d", author.ID))
+
+	data := envelope{
+		"author": author,
+	}
+	err = a.writeJSON(w, http.StatusCreated, data, headers)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// GET /v1/authors/:id
+func (a *application) displayAuthorHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	author, err := a.authorModel.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{
+		"author": author,
+	}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// PATCH /v1/authors/:id
+func (a *application) updateAuthorHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	author, err := a.authorModel.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// pointers so we can tell a missing field from an empty one
+	var incomingData struct {
+		Name *string `json:"name"`
+		Bio  *string `json:"bio"`
+		Born *string `json:"born"`
+		Died *string `json:"died"`
+	}
+
+	err = a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	if incomingData.Name != nil {
+		author.Name = *incomingData.Name
+	}
+	if incomingData.Bio != nil {
+		author.Bio = *incomingData.Bio
+	}
+	if incomingData.Born != nil {
+		author.Born = *incomingData.Born
+	}
+	if incomingData.Died != nil {
+		author.Died = *incomingData.Died
+	}
+
+	v := validator.New()
+	data.ValidateAuthor(v, author)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.authorModel.Update(r.Context(), author)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateAuthor):
+			v.AddError("name", "an author with this name already exists")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{
+		"author": author,
+	}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// DELETE /v1/authors/:id
+func (a *application) deleteAuthorHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	err = a.authorModel.Delete(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrAuthorHasQuotes):
+			a.conflictResponse(w, r, "the author still has quotes, delete or reassign them first")
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{
+		"message": "author successfully deleted",
+	}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// GET /v1/authors?name=...&page=...&page_size=...&sort=...
+func (a *application) listAuthorsHandler(w http.ResponseWriter, r *http.Request) {
+	queryParameters := r.URL.Query()
+
+	v := validator.New()
+	name := a.getSingleQueryParameter(queryParameters, "name", "")
+	filters := a.readFilters(queryParameters, "name", authorSortSafelist, v)
+
+	data.ValidateFilters(v, filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	authors, metadata, err := a.authorModel.GetAll(r.Context(), name, filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"authors":   authors,
+		"@metadata": metadata,
+	}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// GET /v1/authors/:id/quotes
+// the quotes of one author, filtered and paginated like /v1/quotes
+func (a *application) listAuthorQuotesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	// an unknown author is a 404 rather than an empty list
+	author, err := a.authorModel.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	queryParameters := r.URL.Query()
+
+	v := validator.New()
+	search := a.readQuoteSearch(queryParameters, v)
+	search.AuthorID = author.ID
+	filters := a.readFilters(queryParameters, "id", quoteSortSafelist, v)
+
+	data.ValidateFilters(v, filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	quotes, metadata, err := a.quoteModel.GetAll(r.Context(), search, filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"author":    author,
+		"quotes":    quotes,
+		"@metadata": metadata,
+	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
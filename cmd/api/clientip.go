@@ -0,0 +1,163 @@
+// Filename: cmd/api/clientip.go
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// parse the -trusted-proxies flag: CIDRs or single addresses
+func parseTrustedProxies(val string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, field := range strings.Fields(val) {
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// the forwarding headers clientIP can read
+var proxyHeaders = []string{"X-Forwarded-For", "Forwarded"}
+
+// parse the -trusted-proxy-header flag, any capitalisation will do
+func parseProxyHeader(val string) (string, error) {
+	for _, header := range proxyHeaders {
+		if strings.EqualFold(val, header) {
+			return header, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported header %q, use one of %s", val, strings.Join(proxyHeaders, ", "))
+}
+
+func isTrustedProxy(addr netip.Addr, trusted []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP works out the address of the client. The forwarding headers
+// are only believed when they were added by one of our trusted proxies:
+// we walk the hops from the closest one back and stop at the first
+// address that isn't a trusted proxy, since anything before it may have
+// been made up by the client. Only the header our proxies write is
+// read (X-Forwarded-For or the Forwarded header of RFC 7239): they pass
+// the other one on untouched, so whatever it says came from the client
+func clientIP(r *http.Request, trusted []netip.Prefix, header string) string {
+	peer, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		// not an ip:port, e.g. a unix socket. Use it as it is
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return host
+	}
+
+	client := peer.Addr().Unmap()
+	if !isTrustedProxy(client, trusted) {
+		return client.String()
+	}
+
+	var hops []string
+	switch header {
+	case "Forwarded":
+		hops = forwardedFor(r.Header)
+	default:
+		hops = xForwardedFor(r.Header)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseNode(hops[i])
+		if !ok {
+			// "unknown", an obfuscated name or garbage. The
+			// last proxy we know about is as far as we can go
+			break
+		}
+		client = addr
+		if !isTrustedProxy(addr, trusted) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// the for= values of the Forwarded headers, nil when there are none
+func forwardedFor(header http.Header) []string {
+	var hops []string
+	for _, line := range header.Values("Forwarded") {
+		for _, element := range splitOutsideQuotes(line, ',') {
+			for _, pair := range splitOutsideQuotes(element, ';') {
+				key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(key, "for") {
+					hops = append(hops, strings.Trim(value, `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// the addresses of the X-Forwarded-For headers, nil when there are none
+func xForwardedFor(header http.Header) []string {
+	var hops []string
+	for _, line := range header.Values("X-Forwarded-For") {
+		for hop := range strings.SplitSeq(line, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// split on sep, leaving the separators inside quoted strings alone
+func splitOutsideQuotes(s string, sep byte) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			// skip the escaped character
+			i++
+		case sep:
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// An address the way the forwarding headers write it:
+// 192.0.2.60, 192.0.2.60:4711, 2001:db8::17 or [2001:db8::17]:4711
+func parseNode(node string) (netip.Addr, bool) {
+	if addrPort, err := netip.ParseAddrPort(node); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	node = strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+	addr, err := netip.ParseAddr(node)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
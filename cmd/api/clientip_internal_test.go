@@ -0,0 +1,80 @@
+// Filename: cmd/api/clientip_internal_test.go
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := parseTrustedProxies("10.0.0.0/8 2001:db8:ffff::/48 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     string
+		headers    map[string][]string
+		want       string
+	}{
+		{"no proxy", "203.0.113.7:5000", "X-Forwarded-For", nil, "203.0.113.7"},
+		{"untrusted peer can't forward", "203.0.113.7:5000", "X-Forwarded-For",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.7"},
+		{"trusted peer without headers", "10.1.2.3:5000", "X-Forwarded-For", nil, "10.1.2.3"},
+		{"x-forwarded-for", "10.1.2.3:5000", "X-Forwarded-For",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"spoofed hops are skipped", "10.1.2.3:5000", "X-Forwarded-For",
+			map[string][]string{"X-Forwarded-For": {"1.1.1.1, 198.51.100.1, 10.9.9.9"}}, "198.51.100.1"},
+		{"several header lines", "10.1.2.3:5000", "X-Forwarded-For",
+			map[string][]string{"X-Forwarded-For": {"1.1.1.1", "198.51.100.1"}}, "198.51.100.1"},
+		{"single trusted address", "192.0.2.1:5000", "X-Forwarded-For",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"forwarded", "10.1.2.3:5000", "Forwarded",
+			map[string][]string{"Forwarded": {`for=192.0.2.60;proto=http;by=203.0.113.43`}}, "192.0.2.60"},
+		{"forwarded ipv6 with port", "[2001:db8:ffff::1]:443", "Forwarded",
+			map[string][]string{"Forwarded": {`for=1.1.1.1, for="[2001:db8:cafe::17]:4711"`}}, "2001:db8:cafe::17"},
+		{"forwarded is ignored behind an x-forwarded-for proxy", "10.1.2.3:5000", "X-Forwarded-For",
+			map[string][]string{"Forwarded": {"for=192.0.2.60"}, "X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"a spoofed forwarded alone is ignored too", "10.1.2.3:5000", "X-Forwarded-For",
+			map[string][]string{"Forwarded": {"for=192.0.2.60"}}, "10.1.2.3"},
+		{"x-forwarded-for is ignored behind a forwarded proxy", "10.1.2.3:5000", "Forwarded",
+			map[string][]string{"Forwarded": {"for=192.0.2.60"}, "X-Forwarded-For": {"198.51.100.1"}}, "192.0.2.60"},
+		{"unknown hop stops the walk", "10.1.2.3:5000", "Forwarded",
+			map[string][]string{"Forwarded": {"for=unknown, for=10.4.4.4"}}, "10.4.4.4"},
+		{"ipv4 mapped peer", "[::ffff:10.1.2.3]:5000", "X-Forwarded-For",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/quotes", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for key, values := range tt.headers {
+				for _, value := range values {
+					r.Header.Add(key, value)
+				}
+			}
+			if got := clientIP(r, trusted, tt.header); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	_, err = parseTrustedProxies("10.0.0.0/33")
+	if err == nil {
+		t.Error("expected an error for a bad CIDR")
+	}
+
+	header, err := parseProxyHeader("forwarded")
+	if err != nil || header != "Forwarded" {
+		t.Errorf("expected Forwarded, got %q (%v)", header, err)
+	}
+	_, err = parseProxyHeader("X-Real-IP")
+	if err == nil {
+		t.Error("expected an error for an unsupported header")
+	}
+}
@@ -4,6 +4,7 @@ package main
 import (
 	"errors"
 	"fmt"
+	"math/rand/v2"
 	"net/http"
 
 	// import the data package which contains the definition for Comment
@@ -16,11 +17,20 @@ func (a *application) createQuoteHandler(w http.ResponseWriter,
                                                       r *http.Request) { 
     // create a struct to hold a comment
     // we use struct tags[``] to make the names display in lowercase
+    // the author can be an existing author's id or a name
     var incomingData struct {
         Content  string  `json:"content"`
         Author   string  `json:"author"`
+        AuthorID int64   `json:"author_id"`
+        Tags     []string `json:"tags"`
     }
 
+	// refuse a format we can't send before anything is created
+   if _, ok := negotiateFormat(r); !ok {
+       a.notAcceptableResponse(w, r)
+       return
+   }
+
 	// perform the decoding
    err := a.readJSON(w, r, &incomingData)
    if err != nil {
@@ -34,6 +44,10 @@ func (a *application) createQuoteHandler(w http.ResponseWriter,
 	quote := &data.Quotes {
 		Content: incomingData.Content,
 		Author: incomingData.Author,
+		AuthorID: incomingData.AuthorID,
+		Tags: data.NormalizeTags(incomingData.Tags),
+		// the quote belongs to whoever added it
+		CreatedBy: a.contextGetUser(r).ID,
 	}
 	// Initialize a Validator instance
 	v := validator.New()
@@ -45,23 +59,28 @@ func (a *application) createQuoteHandler(w http.ResponseWriter,
 	}
 
 	// Add the quote to the database table
-   err = a.quoteModel.Insert(quote)
+   err = a.quoteModel.Insert(r.Context(), quote)
    if err != nil {
-       a.serverErrorResponse(w, r, err)
+       switch {
+           case errors.Is(err, data.ErrAuthorNotFound):
+              v.AddError("author_id", "does not exist")
+              a.failedValidationResponse(w, r, v.Errors)
+           default:
+              a.serverErrorResponse(w, r, err)
+       }
        return
    }
 
 
-// for now display the result
-   fmt.Fprintf(w, "%+v\n", incomingData)
       // Set a Location header. The path to the newly created quote
    headers := make(http.Header)
    headers.Set("Location", fmt.Sprintf("/v1/quotes/%d", quote.ID))
-   // Send a JSON response with 201 (new resource created) status code
+   headers.Set("ETag", quoteETag(quote))
+   // Send the quote with 201 (new resource created) status code
   data := envelope{
          "quote": quote,
        }
-  err = a.writeJSON(w, http.StatusCreated, data, headers)
+  err = a.writeResponse(w, r, http.StatusCreated, data, headers)
   if err != nil {
        a.serverErrorResponse(w, r, err)
        return
@@ -84,7 +103,7 @@ func (a *application)displayQuoteHandler(
 	}
 
 	// Call Get() to retrieve the quote with the specified id
-	quote, err := a.quoteModel.Get(id)
+	quote, err := a.quoteModel.Get(r.Context(), id)
 	if err != nil {
 		switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -95,11 +114,17 @@ func (a *application)displayQuoteHandler(
 		return 
 	}
 
+	// the client can ask again with If-None-Match or
+	// If-Modified-Since and get a 304 if nothing changed
+    headers := make(http.Header)
+    headers.Set("ETag", quoteETag(quote))
+    headers.Set("Last-Modified", quote.UpdatedAt.UTC().Format(http.TimeFormat))
+
 	// display the quote
     data := envelope {
                 "quote": quote,
             }
-    err = a.writeJSON(w, http.StatusOK, data, nil)
+    err = a.writeResponse(w, r, http.StatusOK, data, headers)
     if err != nil {
        a.serverErrorResponse(w, r, err)
        return 
@@ -117,8 +142,14 @@ func (a *application)updateQuoteHandler(
        return 
    }
 
+   // refuse a format we can't send before anything is changed
+   if _, ok := negotiateFormat(r); !ok {
+       a.notAcceptableResponse(w, r)
+       return
+   }
+
    // Call Get() to retrieve the quote with the specified id
-   quote, err := a.quoteModel.Get(id)
+   quote, err := a.quoteModel.Get(r.Context(), id)
    if err != nil {
        switch {
            case errors.Is(err, data.ErrRecordNotFound):
@@ -129,6 +160,24 @@ func (a *application)updateQuoteHandler(
        return 
    }
 
+   // only the creator (or an admin) may change the quote
+   allowed, err := a.canModifyQuote(r, quote)
+   if err != nil {
+       a.serverErrorResponse(w, r, err)
+       return
+   }
+   if !allowed {
+       a.notPermittedResponse(w, r)
+       return
+   }
+
+   // the client can make sure it is changing the quote it read by
+   // sending its ETag in If-Match (or If-Unmodified-Since). Someone
+   // updating it between here and Update() still gets a 409
+   if !a.checkQuotePreconditions(w, r, quote) {
+       return
+   }
+
    // Use our temporary incomingData struct to hold the data
 // Note: I have changed the types to pointer to differentiate
 // between the client leaving a field empty intentionally
@@ -136,6 +185,8 @@ func (a *application)updateQuoteHandler(
  var incomingData struct {
         Content  *string  `json:"content"`
         Author   *string  `json:"author"`
+        AuthorID *int64   `json:"author_id"`
+        Tags     *[]string `json:"tags"`
     }  
 
 
@@ -152,8 +203,20 @@ func (a *application)updateQuoteHandler(
    }
 
    // if incomingData.Author is nil, no update was provided
+   // a new name is looked up (or added) as an author
    if incomingData.Author != nil {
        quote.Author = *incomingData.Author
+       quote.AuthorID = 0
+   }
+
+   // if incomingData.AuthorID is nil, no update was provided
+   if incomingData.AuthorID != nil {
+       quote.AuthorID = *incomingData.AuthorID
+   }
+
+   // if incomingData.Tags is nil, the tags stay as they are
+   if incomingData.Tags != nil {
+       quote.Tags = data.NormalizeTags(*incomingData.Tags)
    }
 
 // Before we write the updates to the DB let's validate
@@ -165,15 +228,26 @@ func (a *application)updateQuoteHandler(
    }
 
    // perform the update
-    err = a.quoteModel.Update(quote)
+    err = a.quoteModel.Update(r.Context(), quote)
     if err != nil {
-       a.serverErrorResponse(w, r, err)
+       switch {
+           case errors.Is(err, data.ErrEditConflict):
+              a.editConflictResponse(w, r)
+           case errors.Is(err, data.ErrAuthorNotFound):
+              v.AddError("author_id", "does not exist")
+              a.failedValidationResponse(w, r, v.Errors)
+           default:
+              a.serverErrorResponse(w, r, err)
+       }
        return 
    }
+   headers := make(http.Header)
+   headers.Set("ETag", quoteETag(quote))
+
    data := envelope {
                 "quote": quote,
           }
-   err = a.writeJSON(w, http.StatusOK, data, nil)
+   err = a.writeResponse(w, r, http.StatusOK, data, headers)
    if err != nil {
        a.serverErrorResponse(w, r, err)
        return 
@@ -189,7 +263,33 @@ func (a *application)deleteQuoteHandler(
        return 
    }
 
-      err = a.quoteModel.Delete(id)
+   // only the creator (or an admin) may delete the quote
+   quote, err := a.quoteModel.Get(r.Context(), id)
+   if err != nil {
+       switch {
+           case errors.Is(err, data.ErrRecordNotFound):
+              a.notFoundResponse(w, r)
+           default:
+              a.serverErrorResponse(w, r, err)
+       }
+       return 
+   }
+
+   allowed, err := a.canModifyQuote(r, quote)
+   if err != nil {
+       a.serverErrorResponse(w, r, err)
+       return
+   }
+   if !allowed {
+       a.notPermittedResponse(w, r)
+       return
+   }
+
+   if !a.checkQuotePreconditions(w, r, quote) {
+       return
+   }
+
+      err = a.quoteModel.Delete(r.Context(), id)
 
    if err != nil {
        switch {
@@ -212,6 +312,9 @@ func (a *application)deleteQuoteHandler(
 
 }
 
+// the values the quote listings can be sorted by
+var quoteSortSafelist = []string{"id", "-id", "created_at", "-created_at", "author", "-author"}
+
 func (a *application)listQuotesHandler(
                                                w http.ResponseWriter,
                                                r *http.Request) {
@@ -219,43 +322,19 @@ func (a *application)listQuotesHandler(
     // Create a struct to hold the query parameters
 	// Later on we will add fields for pagination and sorting (filters)
     var queryParametersData struct {
-        Content string
-        Author  string
+        data.QuoteSearch
 		data.Filters
     }
 
 	// get the query parameters from the URL
 	queryParameters := r.URL.Query()
 
-	// Load the query parameters into our struct
-    queryParametersData.Content = a.getSingleQueryParameter(
-                                  queryParameters,
-                                  "content",
-                                  "")      
-
-	queryParametersData.Author = a.getSingleQueryParameter(
-                                  queryParameters,
-                                  "author",
-                                  "") 
-
     v := validator.New()
 
-	queryParametersData.Filters.Page = a.getSingleIntegerParameter(
-								  queryParameters,
-								  "page",
-								  1,
-								  v)
-	queryParametersData.Filters.PageSize = a.getSingleIntegerParameter(
-								  queryParameters,
-								  "page_size",
-								  20,
-								  v)
-	queryParametersData.Filters.Sort = a.getSingleQueryParameter(
-								  queryParameters,
-								  "sort",
-								  "id")
-								  
-	queryParametersData.Filters.SortSafelist = []string{"id", "-id", "created_at", "-created_at", "author", "-author"}
+	// Load the query parameters into our struct
+    queryParametersData.QuoteSearch = a.readQuoteSearch(queryParameters, v)
+
+	queryParametersData.Filters = a.readFilters(queryParameters, "id", quoteSortSafelist, v)
 
 	data.ValidateFilters(v, queryParametersData.Filters)
 	if !v.IsEmpty() {
@@ -263,7 +342,7 @@ func (a *application)listQuotesHandler(
 		return
 	}
 
-	quotes, metadata, err := a.quoteModel.GetAll(queryParametersData.Content, queryParametersData.Author, queryParametersData.Filters)
+	quotes, metadata, err := a.quoteModel.GetAll(r.Context(), queryParametersData.QuoteSearch, queryParametersData.Filters)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
@@ -273,8 +352,49 @@ func (a *application)listQuotesHandler(
 		"quotes": quotes,
 		"@metadata": metadata,
 	}
-	err = a.writeJSON(w, http.StatusOK, data, nil)
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+	}
+
+// GET /v1/quotes/random?count=3&seed=42&author=...&content=...
+// Sending the same seed gives back the same quotes (as long as the
+// matching quotes did not change)
+func (a *application) randomQuotesHandler(w http.ResponseWriter, r *http.Request) {
+	queryParameters := r.URL.Query()
+
+	v := validator.New()
+	search := a.readQuoteSearch(queryParameters, v)
+
+	count := a.getSingleIntegerParameter(queryParameters, "count", 1, v)
+	v.Check(count > 0, "count", "must be greater than zero")
+	v.Check(count <= 100, "count", "must not be more than 100")
+
+	// without a seed every request is different, we still send
+	// back the one we used so the client can repeat it
+	seed := rand.Int64()
+	if queryParameters.Get("seed") != "" {
+		seed = int64(a.getSingleIntegerParameter(queryParameters, "seed", 0, v))
+	}
+
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	quotes, err := a.quoteModel.GetRandom(r.Context(), search, count, seed)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"quotes": quotes,
+		"seed":   seed,
 	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
 	}
+}
@@ -0,0 +1,478 @@
+// Filename: cmd/api/comments_internal_test.go
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Lee26Ed/qod/internal/data"
+	"github.com/Lee26Ed/qod/internal/mailer"
+	"github.com/Lee26Ed/qod/internal/ratelimit"
+)
+
+// an application backed by the in-memory store so no database is needed
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	var cfg configuration
+	cfg.env = "testing"
+	cfg.tokens.ttl = time.Hour
+	// the emails end up in files the tests can read
+	cfg.mailer.dir = t.TempDir()
+
+	accounts := data.NewMemoryAccounts()
+	return &application{
+		config:          cfg,
+		logger:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+		quoteModel:      data.NewMemoryQuoteStore(),
+		userModel:       accounts.Users(),
+		tokenModel:      accounts.Tokens(),
+		permissionModel: accounts.Permissions(),
+		apiKeyModel:     accounts.APIKeys(),
+		limiter:         ratelimit.NewMemory(),
+		metrics:         newMetrics(),
+		exports:         make(chan struct{}, 4),
+		mailer:          mailer.New(mailer.FileTransport{Dir: cfg.mailer.dir}, "QOD <no-reply@qod.example>"),
+	}
+}
+
+// add a user holding the permissions and return the
+// headers that send its authentication token
+func newTestUser(t *testing.T, app *application, email string, permissions ...string) map[string]string {
+	t.Helper()
+
+	ctx := t.Context()
+	user := &data.User{Name: "Test User", Email: email, Activated: true}
+	err := app.userModel.Insert(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.permissionModel.AddForUser(ctx, user.ID, permissions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := app.tokenModel.New(ctx, user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]string{"Authorization": "Bearer " + token.Plaintext}
+}
+
+// send a request through the full router and decode the JSON body
+func sendRequest(t *testing.T, handler http.Handler, method string, url string, body string, headers map[string]string) (*httptest.ResponseRecorder, map[string]any) {
+	t.Helper()
+
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	r := httptest.NewRequest(method, url, reader)
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	var decoded map[string]any
+	if w.Body.Len() > 0 {
+		err := json.Unmarshal(w.Body.Bytes(), &decoded)
+		if err != nil {
+			t.Fatalf("%s %s: response is not JSON: %v\n%s", method, url, err, w.Body.String())
+		}
+	}
+	return w, decoded
+}
+
+func TestCreateAndDisplayQuote(t *testing.T) {
+	app := newTestApplication(t)
+	handler := app.routes()
+	auth := newTestUser(t, app, "writer@example.com", data.PermissionQuotesWrite)
+
+	w, body := sendRequest(t, handler, http.MethodPost, "/v1/quotes",
+		`{"content": "Stay hungry, stay foolish", "author": "Steve Jobs"}`, auth)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, w.Code)
+	}
+	if got := w.Header().Get("Location"); got != "/v1/quotes/1" {
+		t.Errorf("expected Location %q, got %q", "/v1/quotes/1", got)
+	}
+	quote := body["quote"].(map[string]any)
+	if quote["version"] != float64(1) {
+		t.Errorf("expected version 1, got %v", quote["version"])
+	}
+
+	w, body = sendRequest(t, handler, http.MethodGet, "/v1/quotes/1", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	quote = body["quote"].(map[string]any)
+	if quote["author"] != "Steve Jobs" {
+		t.Errorf("expected author %q, got %v", "Steve Jobs", quote["author"])
+	}
+
+	w, _ = sendRequest(t, handler, http.MethodGet, "/v1/quotes/2", "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestCreateQuoteValidation(t *testing.T) {
+	app := newTestApplication(t)
+	handler := app.routes()
+	auth := newTestUser(t, app, "writer@example.com", data.PermissionQuotesWrite)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"missing author", `{"content": "hello"}`, http.StatusUnprocessableEntity},
+		{"blank author", `{"content": "hello", "author": "   "}`, http.StatusUnprocessableEntity},
+		{"author too long", `{"content": "hello", "author": "` + strings.Repeat("a", 26) + `"}`, http.StatusUnprocessableEntity},
+		{"padded author", `{"content": "hello", "author": "  ` + strings.Repeat("a", 25) + `  "}`, http.StatusCreated},
+		{"unknown field", `{"content": "hello", "author": "me", "year": 1}`, http.StatusBadRequest},
+		{"badly-formed", `{"content": `, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, _ := sendRequest(t, handler, http.MethodPost, "/v1/quotes", tt.body, auth)
+			if w.Code != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+}
+
+func TestUpdateQuoteEditConflict(t *testing.T) {
+	app := newTestApplication(t)
+	handler := app.routes()
+	auth := newTestUser(t, app, "writer@example.com", data.PermissionQuotesWrite)
+
+	sendRequest(t, handler, http.MethodPost, "/v1/quotes",
+		`{"content": "Know thyself", "author": "Socrates"}`, auth)
+
+	w, body := sendRequest(t, handler, http.MethodPatch, "/v1/quotes/1",
+		`{"author": "Thales"}`, map[string]string{"Authorization": auth["Authorization"], "If-Match": `"1-1"`})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	quote := body["quote"].(map[string]any)
+	if quote["version"] != float64(2) {
+		t.Errorf("expected version 2, got %v", quote["version"])
+	}
+	if etag := w.Header().Get("ETag"); etag != `"1-2"` {
+		t.Errorf(`expected the ETag "1-2", got %q`, etag)
+	}
+
+	// a second client still holding version 1
+	w, _ = sendRequest(t, handler, http.MethodPatch, "/v1/quotes/1",
+		`{"author": "Plato"}`, map[string]string{"Authorization": auth["Authorization"], "If-Match": `"1-1"`})
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected status %d, got %d", http.StatusPreconditionFailed, w.Code)
+	}
+
+	// the bare version the older clients send still gets a 409
+	for _, ifMatch := range []string{"1", `"1"`} {
+		w, _ = sendRequest(t, handler, http.MethodPatch, "/v1/quotes/1",
+			`{"author": "Plato"}`, map[string]string{"Authorization": auth["Authorization"], "If-Match": ifMatch})
+		if w.Code != http.StatusConflict {
+			t.Errorf("If-Match %s: expected status %d, got %d", ifMatch, http.StatusConflict, w.Code)
+		}
+	}
+	w, _ = sendRequest(t, handler, http.MethodPatch, "/v1/quotes/1",
+		`{"author": "Plato"}`, map[string]string{"Authorization": auth["Authorization"], "If-Match": "2"})
+	if w.Code != http.StatusOK {
+		t.Errorf("If-Match 2: expected status %d, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestListQuotes(t *testing.T) {
+	app := newTestApplication(t)
+	handler := app.routes()
+	auth := newTestUser(t, app, "writer@example.com", data.PermissionQuotesWrite)
+
+	quotes := []string{
+		`{"content": "The unexamined life is not worth living", "author": "Socrates"}`,
+		`{"content": "Life is what happens", "author": "John Lennon"}`,
+		`{"content": "Imagination is more important than knowledge", "author": "Albert Einstein"}`,
+	}
+	for _, quote := range quotes {
+		sendRequest(t, handler, http.MethodPost, "/v1/quotes", quote, auth)
+	}
+
+	tests := []struct {
+		name    string
+		url     string
+		status  int
+		authors []string
+		total   float64
+	}{
+		{"all", "/v1/quotes", http.StatusOK, []string{"Socrates", "John Lennon", "Albert Einstein"}, 3},
+		{"content filter", "/v1/quotes?content=life", http.StatusOK, []string{"Socrates", "John Lennon"}, 2},
+		{"author filter", "/v1/quotes?author=lennon", http.StatusOK, []string{"John Lennon"}, 1},
+		{"sorted", "/v1/quotes?sort=-author", http.StatusOK, []string{"Socrates", "John Lennon", "Albert Einstein"}, 3},
+		{"second page", "/v1/quotes?sort=author&page=2&page_size=2", http.StatusOK, []string{"Socrates"}, 3},
+		{"no match", "/v1/quotes?author=plato", http.StatusOK, []string{}, 0},
+		{"bad sort", "/v1/quotes?sort=content", http.StatusUnprocessableEntity, nil, 0},
+		{"bad page", "/v1/quotes?page=abc", http.StatusUnprocessableEntity, nil, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, body := sendRequest(t, handler, http.MethodGet, tt.url, "", nil)
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, w.Code)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			results := body["quotes"].([]any)
+			if len(results) != len(tt.authors) {
+				t.Fatalf("expected %d quotes, got %d", len(tt.authors), len(results))
+			}
+			for i, result := range results {
+				author := result.(map[string]any)["author"]
+				if author != tt.authors[i] {
+					t.Errorf("quote %d: expected author %q, got %v", i, tt.authors[i], author)
+				}
+			}
+
+			metadata := body["@metadata"].(map[string]any)
+			if total, _ := metadata["total_records"].(float64); total != tt.total {
+				t.Errorf("expected total_records %v, got %v", tt.total, total)
+			}
+		})
+	}
+}
+
+func TestDeleteQuote(t *testing.T) {
+	app := newTestApplication(t)
+	handler := app.routes()
+	auth := newTestUser(t, app, "writer@example.com", data.PermissionQuotesWrite)
+
+	sendRequest(t, handler, http.MethodPost, "/v1/quotes",
+		`{"content": "Carpe diem", "author": "Horace"}`, auth)
+
+	w, _ := sendRequest(t, handler, http.MethodDelete, "/v1/quotes/1", "", auth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	w, _ = sendRequest(t, handler, http.MethodDelete, "/v1/quotes/1", "", auth)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestQuoteOfTheDay(t *testing.T) {
+	app := newTestApplication(t)
+	handler := app.routes()
+	auth := newTestUser(t, app, "writer@example.com", data.PermissionQuotesWrite)
+
+	w, _ := sendRequest(t, handler, http.MethodGet, "/v1/quotes/today", "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d with no quotes, got %d", http.StatusNotFound, w.Code)
+	}
+
+	for _, author := range []string{"Seneca", "Epictetus", "Zeno"} {
+		sendRequest(t, handler, http.MethodPost, "/v1/quotes",
+			`{"content": "Be still", "author": "`+author+`"}`, auth)
+	}
+
+	_, first := sendRequest(t, handler, http.MethodGet, "/v1/quotes/today?tz=America/Belize", "", nil)
+	_, second := sendRequest(t, handler, http.MethodGet, "/v1/quotes/today?tz=America/Belize", "", nil)
+	firstID := first["quote"].(map[string]any)["id"]
+	secondID := second["quote"].(map[string]any)["id"]
+	if firstID != secondID {
+		t.Errorf("expected the same quote all day, got %v and %v", firstID, secondID)
+	}
+
+	w, _ = sendRequest(t, handler, http.MethodGet, "/v1/quotes/today?tz=Mars/Olympus", "", nil)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status %d for a bad time zone, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+}
+
+func TestPinDailyQuote(t *testing.T) {
+	app := newTestApplication(t)
+	handler := app.routes()
+	auth := newTestUser(t, app, "writer@example.com", data.PermissionQuotesWrite, data.PermissionQuotesAdmin)
+
+	for _, author := range []string{"Seneca", "Epictetus"} {
+		sendRequest(t, handler, http.MethodPost, "/v1/quotes",
+			`{"content": "Be still", "author": "`+author+`"}`, auth)
+	}
+
+	// the day after tomorrow in UTC hasn't started anywhere yet
+	future := time.Now().UTC().AddDate(0, 0, 2).Format(data.DayLayout)
+	// while today in UTC+14 always has
+	started := time.Now().In(time.FixedZone("UTC+14", 14*60*60)).Format(data.DayLayout)
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format(data.DayLayout)
+
+	tests := []struct {
+		name   string
+		date   string
+		body   string
+		status int
+	}{
+		{"future date", future, `{"quote_id": 2}`, http.StatusOK},
+		{"started somewhere", started, `{"quote_id": 2}`, http.StatusUnprocessableEntity},
+		{"past date", yesterday, `{"quote_id": 2}`, http.StatusUnprocessableEntity},
+		{"bad date", "tomorrow", `{"quote_id": 2}`, http.StatusUnprocessableEntity},
+		{"unknown quote", future, `{"quote_id": 99}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, _ := sendRequest(t, handler, http.MethodPut, "/v1/daily-quotes/"+tt.date, tt.body, auth)
+			if w.Code != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+}
+
+func TestRandomQuotes(t *testing.T) {
+	app := newTestApplication(t)
+	handler := app.routes()
+	auth := newTestUser(t, app, "writer@example.com", data.PermissionQuotesWrite)
+
+	for _, author := range []string{"Seneca", "Epictetus", "Zeno", "Marcus Aurelius", "Cicero"} {
+		sendRequest(t, handler, http.MethodPost, "/v1/quotes",
+			`{"content": "Be still", "author": "`+author+`"}`, auth)
+	}
+
+	ids := func(body map[string]any) []any {
+		result := []any{}
+		for _, quote := range body["quotes"].([]any) {
+			result = append(result, quote.(map[string]any)["id"])
+		}
+		return result
+	}
+
+	w, first := sendRequest(t, handler, http.MethodGet, "/v1/quotes/random?count=3&seed=42", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	_, second := sendRequest(t, handler, http.MethodGet, "/v1/quotes/random?count=3&seed=42", "", nil)
+	if got, want := ids(second), ids(first); len(got) != 3 || !slices.Equal(got, want) {
+		t.Errorf("expected the same 3 quotes for the same seed, got %v and %v", want, got)
+	}
+
+	_, body := sendRequest(t, handler, http.MethodGet, "/v1/quotes/random?count=10&author=zeno", "", nil)
+	if got := ids(body); len(got) != 1 || got[0] != float64(3) {
+		t.Errorf("expected only quote 3 to match, got %v", got)
+	}
+
+	w, _ = sendRequest(t, handler, http.MethodGet, "/v1/quotes/random?count=0", "", nil)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status %d, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+}
+
+func TestQuoteTags(t *testing.T) {
+	app := newTestApplication(t)
+	handler := app.routes()
+	auth := newTestUser(t, app, "writer@example.com", data.PermissionQuotesWrite)
+
+	quotes := []string{
+		`{"content": "Courage is grace under pressure", "author": "Hemingway", "tags": ["Courage", "life "]}`,
+		`{"content": "Life is short", "author": "Hippocrates", "tags": ["life"]}`,
+		`{"content": "Fortune favours the bold", "author": "Virgil", "tags": ["courage"]}`,
+	}
+	for _, quote := range quotes {
+		sendRequest(t, handler, http.MethodPost, "/v1/quotes", quote, auth)
+	}
+
+	_, body := sendRequest(t, handler, http.MethodGet, "/v1/quotes/1", "", nil)
+	tags := body["quote"].(map[string]any)["tags"].([]any)
+	if len(tags) != 2 || tags[0] != "courage" || tags[1] != "life" {
+		t.Errorf("expected normalized tags [courage life], got %v", tags)
+	}
+
+	tests := []struct {
+		url   string
+		total float64
+	}{
+		{"/v1/quotes?tags=courage", 2},
+		{"/v1/quotes?tags=courage,life", 3},
+		{"/v1/quotes?tags=courage,life&tags_mode=all", 1},
+		{"/v1/quotes?tags=wisdom", 0},
+	}
+	for _, tt := range tests {
+		_, body := sendRequest(t, handler, http.MethodGet, tt.url, "", nil)
+		metadata := body["@metadata"].(map[string]any)
+		if total, _ := metadata["total_records"].(float64); total != tt.total {
+			t.Errorf("%s: expected %v quotes, got %v", tt.url, tt.total, total)
+		}
+	}
+
+	w, _ := sendRequest(t, handler, http.MethodPatch, "/v1/quotes/2", `{"tags": ["life", "medicine"]}`, auth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	_, body = sendRequest(t, handler, http.MethodGet, "/v1/tags", "", nil)
+	want := []string{"courage:2", "life:2", "medicine:1"}
+	got := []string{}
+	for _, tag := range body["tags"].([]any) {
+		tag := tag.(map[string]any)
+		got = append(got, fmt.Sprintf("%s:%v", tag["name"], tag["quote_count"]))
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected tags %v, got %v", want, got)
+	}
+
+	w, _ = sendRequest(t, handler, http.MethodGet, "/v1/quotes?tags_mode=some", "", nil)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status %d, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+}
+
+func TestQuoteAuthors(t *testing.T) {
+	app := newTestApplication(t)
+	handler := app.routes()
+	auth := newTestUser(t, app, "writer@example.com", data.PermissionQuotesWrite)
+
+	_, first := sendRequest(t, handler, http.MethodPost, "/v1/quotes",
+		`{"content": "Waste no more time", "author": "Marcus Aurelius"}`, auth)
+	_, second := sendRequest(t, handler, http.MethodPost, "/v1/quotes",
+		`{"content": "The best revenge", "author": " Marcus Aurelius "}`, auth)
+	firstAuthor := first["quote"].(map[string]any)["author_id"]
+	secondAuthor := second["quote"].(map[string]any)["author_id"]
+	if firstAuthor != secondAuthor {
+		t.Errorf("expected the same author, got ids %v and %v", firstAuthor, secondAuthor)
+	}
+
+	w, body := sendRequest(t, handler, http.MethodPost, "/v1/quotes",
+		`{"content": "You have power over your mind", "author_id": 1}`, auth)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, w.Code)
+	}
+	if got := body["quote"].(map[string]any)["author"]; got != "Marcus Aurelius" {
+		t.Errorf("expected author %q, got %v", "Marcus Aurelius", got)
+	}
+
+	w, _ = sendRequest(t, handler, http.MethodPost, "/v1/quotes",
+		`{"content": "Who?", "author_id": 42}`, auth)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status %d, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+
+	// the authors themselves are only kept in PostgreSQL
+	w, _ = sendRequest(t, handler, http.MethodGet, "/v1/authors/1", "", nil)
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("expected status %d, got %d", http.StatusNotImplemented, w.Code)
+	}
+}
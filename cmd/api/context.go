@@ -0,0 +1,100 @@
+// Filename: cmd/api/context.go
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Lee26Ed/qod/internal/data"
+)
+
+// our own type for the context keys so they can't clash with
+// keys set by other packages
+type contextKey string
+
+const (
+	userContextKey        = contextKey("user")
+	apiKeyContextKey      = contextKey("api_key")
+	clientIPContextKey    = contextKey("client_ip")
+	requestInfoContextKey = contextKey("request_info")
+)
+
+// requestInfo is filled in as the request makes its way to the
+// handler, for the middleware that created it to read afterwards
+type requestInfo struct {
+	// the X-Request-ID
+	id string
+	// the pattern of the matched route, e.g. /v1/quotes/:id
+	route string
+	// who made the request, 0 for anonymous callers
+	userID int64
+}
+
+// return a copy of the request with the user added to its context
+func (a *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	// the access log is written further out, where the user can't be seen
+	if info := a.contextGetRequestInfo(r); info != nil {
+		info.userID = user.ID
+	}
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// the user set by the authenticate middleware. Every request
+// goes through it so a missing user is a bug
+func (a *application) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+	return user
+}
+
+// return a copy of the request with the API key added to its context
+func (a *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// the API key found by the resolveAPIKey middleware,
+// nil when the request didn't send a valid one
+func (a *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+// return a copy of the request with the client's address added to its context
+func (a *application) contextSetClientIP(r *http.Request, ip string) *http.Request {
+	ctx := context.WithValue(r.Context(), clientIPContextKey, ip)
+	return r.WithContext(ctx)
+}
+
+// the address the realIP middleware resolved. Should the request not
+// have gone through it we work it out again
+func (a *application) contextGetClientIP(r *http.Request) string {
+	ip, ok := r.Context().Value(clientIPContextKey).(string)
+	if !ok {
+		return clientIP(r, a.config.trustedProxies, a.config.proxyHeader)
+	}
+	return ip
+}
+
+// return a copy of the request with the info added to its context
+func (a *application) contextSetRequestInfo(r *http.Request, info *requestInfo) *http.Request {
+	ctx := context.WithValue(r.Context(), requestInfoContextKey, info)
+	return r.WithContext(ctx)
+}
+
+// the X-Request-ID of the request, "" when it has none
+func (a *application) contextGetRequestID(r *http.Request) string {
+	if info := a.contextGetRequestInfo(r); info != nil {
+		return info.id
+	}
+	return ""
+}
+
+// the info of the request, nil when no middleware created one
+func (a *application) contextGetRequestInfo(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestInfoContextKey).(*requestInfo)
+	return info
+}
@@ -0,0 +1,115 @@
+// Filename: cmd/api/daily.go
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Lee26Ed/qod/internal/data"
+	"github.com/Lee26Ed/qod/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// GET /v1/quotes/today?tz=America/Belize
+// Everyone asking on the same calendar day (in the given time zone)
+// gets the same quote
+func (a *application) quoteOfTheDayHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	tz := a.getSingleQueryParameter(r.URL.Query(), "tz", "UTC")
+	location, err := time.LoadLocation(tz)
+	// "Local" would depend on the server's settings so we don't allow it
+	v.Check(err == nil && tz != "Local", "tz", "must be a valid IANA time zone")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// the calendar day it currently is in that time zone
+	now := time.Now().In(location)
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	daily, err := a.quoteModel.GetDaily(r.Context(), day, a.config.qod.repeatWindow)
+	if err != nil {
+		switch {
+		// there are no quotes to choose from
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{
+		"date":     daily.Day.Format(data.DayLayout),
+		"timezone": location.String(),
+		"pinned":   daily.Pinned,
+		"quote":    daily.Quote,
+	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// the furthest time zone ahead of UTC
+var earliestZone = time.FixedZone("UTC+14", 14*60*60)
+
+// the latest date it is somewhere in the world at now, as
+// midnight UTC like the dates time.Parse gives us
+func latestToday(now time.Time) time.Time {
+	year, month, day := now.In(earliestZone).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// PUT /v1/daily-quotes/:date
+// Pin a specific quote to a future date
+func (a *application) pinDailyQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		QuoteID int64 `json:"quote_id"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	params := httprouter.ParamsFromContext(r.Context())
+	day, err := time.Parse(data.DayLayout, params.ByName("date"))
+	v.Check(err == nil, "date", "must be a date in the format YYYY-MM-DD")
+	// a day that has begun anywhere (?tz= goes up to UTC+14) may
+	// already have shown its quote so it can't change any more
+	v.Check(err != nil || day.After(latestToday(time.Now())), "date", "must be a date that hasn't started anywhere yet")
+	v.Check(incomingData.QuoteID > 0, "quote_id", "must be provided")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.quoteModel.PinDaily(r.Context(), day, incomingData.QuoteID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("quote_id", "does not exist")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{
+		"date":     day.Format(data.DayLayout),
+		"quote_id": incomingData.QuoteID,
+		"pinned":   true,
+	}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
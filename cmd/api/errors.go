@@ -10,13 +10,19 @@ func (a *application) logError(r *http.Request, err error) {
 
    method := r.Method
    uri := r.URL.RequestURI()
-   a.logger.Error(err.Error(), "method", method, "uri", uri)
+   ip := a.contextGetClientIP(r)
+   requestID := a.contextGetRequestID(r)
+   a.logger.Error(err.Error(), "method", method, "uri", uri, "client_ip", ip, "request_id", requestID)
     
 }
 
 // send an error response in JSON
 func (a *application) errorResponseJSON(w http.ResponseWriter, r *http.Request, status int, message any) {
    errorData := envelope{"error": message}
+   // quoting the ID helps us find the request in the logs
+   if requestID := a.contextGetRequestID(r); requestID != "" {
+       errorData["request_id"] = requestID
+   }
    err := a.writeJSON(w, status, errorData, nil)
    if err != nil {
        a.logError(r, err)
@@ -66,7 +72,83 @@ func (a *application) failedValidationResponse(w http.ResponseWriter, r *http.Re
 	a.errorResponseJSON(w, r, http.StatusUnprocessableEntity, errors)
 }
 
+// send an error response when the quote was changed by someone else
+// between the client reading it and sending its update (409)
+func (a *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
+	message := "unable to update the record due to an edit conflict, please try again"
+	a.errorResponseJSON(w, r, http.StatusConflict, message)
+}
+
+// the quote changed since the client read it, going by its
+// If-Match or If-Unmodified-Since header (412)
+func (a *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the quote was changed since you read it, fetch it again and retry"
+	a.errorResponseJSON(w, r, http.StatusPreconditionFailed, message)
+}
+
+// send a 409 when the request clashes with the current state of the resource
+func (a *application) conflictResponse(w http.ResponseWriter, r *http.Request, message string) {
+	a.errorResponseJSON(w, r, http.StatusConflict, message)
+}
+
+// send a 501 for the features that only exist with PostgreSQL storage
+func (a *application) databaseRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this resource is not available with in-memory storage"
+	a.errorResponseJSON(w, r, http.StatusNotImplemented, message)
+}
+
+// the email or password sent to get a token is wrong (401)
+func (a *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid authentication credentials"
+	a.errorResponseJSON(w, r, http.StatusUnauthorized, message)
+}
+
+// the bearer token is malformed, unknown or expired (401)
+func (a *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	message := "invalid or missing authentication token"
+	a.errorResponseJSON(w, r, http.StatusUnauthorized, message)
+}
+
+// the X-API-Key header holds an unknown or revoked key (401)
+func (a *application) invalidAPIKeyResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or revoked API key"
+	a.errorResponseJSON(w, r, http.StatusUnauthorized, message)
+}
+
+// the route needs a logged in user but none was given (401)
+func (a *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	message := "you must be authenticated to access this resource"
+	a.errorResponseJSON(w, r, http.StatusUnauthorized, message)
+}
+
+// the user is known but lacks the permission for the resource (403)
+func (a *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	a.errorResponseJSON(w, r, http.StatusForbidden, message)
+}
+
+// the account exists but its email address was not verified yet (403)
+func (a *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account must be activated to access this resource"
+	a.errorResponseJSON(w, r, http.StatusForbidden, message)
+}
+
+// the client accepts none of the formats the resource comes in (406)
+func (a *application) notAcceptableResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource is only available as application/json, text/plain, text/csv or application/xml"
+	a.errorResponseJSON(w, r, http.StatusNotAcceptable, message)
+}
+
 func (a *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
 	a.errorResponseJSON(w, r, http.StatusTooManyRequests, message)
-}
\ No newline at end of file
+}
+
+// every slot for a long running job (the exports) is taken (503)
+func (a *application) serverBusyResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "10")
+	message := "the server is busy, please try again later"
+	a.errorResponseJSON(w, r, http.StatusServiceUnavailable, message)
+}
@@ -0,0 +1,118 @@
+// Filename: cmd/api/etag_internal_test.go
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuoteETags(t *testing.T) {
+	app := newTestApplication(t)
+	handler := app.routes()
+	auth := newTestUser(t, app, "writer@example.com", "quotes:write")
+
+	sendRequest(t, handler, http.MethodPost, "/v1/quotes",
+		`{"content": "Know thyself", "author": "Socrates"}`, auth)
+
+	get := func(url string, headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, url, nil)
+		for key, value := range headers {
+			r.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/v1/quotes/1", nil)
+	if etag := w.Header().Get("ETag"); etag != `"1-1"` {
+		t.Fatalf(`expected the strong ETag "1-1", got %q`, etag)
+	}
+	lastModified := w.Header().Get("Last-Modified")
+	if lastModified == "" {
+		t.Error("expected a Last-Modified header")
+	}
+
+	tests := []struct {
+		name    string
+		url     string
+		headers map[string]string
+		status  int
+	}{
+		{"same etag", "/v1/quotes/1", map[string]string{"If-None-Match": `"1-1"`}, http.StatusNotModified},
+		{"one of several", "/v1/quotes/1", map[string]string{"If-None-Match": `"1-0", W/"1-1"`}, http.StatusNotModified},
+		{"any", "/v1/quotes/1", map[string]string{"If-None-Match": "*"}, http.StatusNotModified},
+		{"other etag", "/v1/quotes/1", map[string]string{"If-None-Match": `"1-0"`}, http.StatusOK},
+		{"json etag for csv", "/v1/quotes/1?format=csv", map[string]string{"If-None-Match": `"1-1"`}, http.StatusOK},
+		{"csv etag", "/v1/quotes/1?format=csv", map[string]string{"If-None-Match": `"1-1-csv"`}, http.StatusNotModified},
+		{"not modified since", "/v1/quotes/1", map[string]string{"If-Modified-Since": lastModified}, http.StatusNotModified},
+		// If-None-Match wins over If-Modified-Since
+		{"etag first", "/v1/quotes/1", map[string]string{"If-None-Match": `"1-0"`, "If-Modified-Since": lastModified}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := get(tt.url, tt.headers)
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.status, w.Code)
+		}
+		if tt.status == http.StatusNotModified && w.Body.Len() != 0 {
+			t.Errorf("%s: expected an empty body, got %q", tt.name, w.Body.String())
+		}
+	}
+
+	// the listings get weak ETags that change with the quotes
+	w = get("/v1/quotes", nil)
+	listETag := w.Header().Get("ETag")
+	if !strings.HasPrefix(listETag, `W/"`) {
+		t.Fatalf("expected a weak ETag on the list, got %q", listETag)
+	}
+	w = get("/v1/quotes", map[string]string{"If-None-Match": listETag})
+	if w.Code != http.StatusNotModified {
+		t.Errorf("list: expected 304, got %d", w.Code)
+	}
+
+	patch := func(body string, headers map[string]string) int {
+		headers["Authorization"] = auth["Authorization"]
+		w, _ := sendRequest(t, handler, http.MethodPatch, "/v1/quotes/1", body, headers)
+		return w.Code
+	}
+	if code := patch(`{"content": "Know yourself"}`, map[string]string{"If-Match": `"1-1-xml"`}); code != http.StatusOK {
+		t.Fatalf("PATCH with the XML ETag: expected 200, got %d", code)
+	}
+	// the update answers in the format asked for, with its ETag
+	r := httptest.NewRequest(http.MethodPatch, "/v1/quotes/1?format=csv", strings.NewReader(`{"content": "Know yourself"}`))
+	r.Header.Set("Authorization", auth["Authorization"])
+	r.Header.Set("If-Match", `"1-2"`)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") ||
+		w.Header().Get("ETag") != `"1-3-csv"` {
+		t.Fatalf("PATCH as CSV: got %d %q %q", w.Code, w.Header().Get("Content-Type"), w.Header().Get("ETag"))
+	}
+	if code := patch(`{"content": "Know thyself"}`, map[string]string{"If-Match": `W/"1-3"`}); code != http.StatusPreconditionFailed {
+		t.Errorf("PATCH with a weak ETag: expected 412, got %d", code)
+	}
+	past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	if code := patch(`{"content": "Know thyself"}`, map[string]string{"If-Unmodified-Since": past}); code != http.StatusPreconditionFailed {
+		t.Errorf("PATCH unmodified since an hour ago: expected 412, got %d", code)
+	}
+
+	w = get("/v1/quotes", map[string]string{"If-None-Match": listETag})
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == listETag {
+		t.Errorf("list after an update: expected 200 and a new ETag, got %d %q", w.Code, w.Header().Get("ETag"))
+	}
+
+	w, _ = sendRequest(t, handler, http.MethodDelete, "/v1/quotes/1", "",
+		map[string]string{"Authorization": auth["Authorization"], "If-Match": `"1-2"`})
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("DELETE with a stale ETag: expected 412, got %d", w.Code)
+	}
+	w, _ = sendRequest(t, handler, http.MethodDelete, "/v1/quotes/1", "",
+		map[string]string{"Authorization": auth["Authorization"], "If-Match": `"1-3"`})
+	if w.Code != http.StatusOK {
+		t.Errorf("DELETE with the current ETag: expected 200, got %d", w.Code)
+	}
+}
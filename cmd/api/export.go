@@ -0,0 +1,105 @@
+// Filename: cmd/api/export.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Lee26Ed/qod/internal/data"
+	"github.com/Lee26Ed/qod/internal/validator"
+)
+
+// how many quotes go out between two flushes of an export
+const exportFlushEvery = 100
+
+// how long the client gets to take each batch of quotes. A big export
+// runs past the server's WriteTimeout so we move the deadline along
+// as the quotes go out, a client that stops reading still gets cut off
+const exportWriteTimeout = 30 * time.Second
+
+// the Content-Type of each export format
+var exportContentTypes = map[string]string{
+	"jsonl": "application/x-ndjson",
+	"csv":   "text/csv; charset=utf-8",
+}
+
+// GET /v1/quotes/export?format=jsonl|csv&author=...&content=...&tags=...&sort=...
+// every quote matching the filters of /v1/quotes, without pages. The
+// quotes are written out as they come from the store. Takes quotes:read
+func (a *application) exportQuotesHandler(w http.ResponseWriter, r *http.Request) {
+	queryParameters := r.URL.Query()
+
+	v := validator.New()
+	search := a.readQuoteSearch(queryParameters, v)
+	sort := a.getSingleQueryParameter(queryParameters, "sort", "id")
+	v.Check(validator.PermittedValue(sort, quoteSortSafelist...), "sort", "invalid sort value")
+	format := a.getSingleQueryParameter(queryParameters, "format", "jsonl")
+	v.Check(validator.PermittedValue(format, data.ExportFormats...), "format", "must be jsonl or csv")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	filters := data.Filters{Sort: sort, SortSafelist: quoteSortSafelist}
+
+	// an export holds a database connection (and a transaction) until
+	// the client has it all, only so many may run at once
+	select {
+	case a.exports <- struct{}{}:
+		defer func() { <-a.exports }()
+	default:
+		a.serverBusyResponse(w, r)
+		return
+	}
+
+	// the recorders of the tests don't support deadlines, that's fine
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+
+	w.Header().Set("Content-Type", exportContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="quotes.%s"`, format))
+	writer, err := data.NewQuoteWriter(w, format)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	count := 0
+	err = a.quoteModel.Export(r.Context(), search, filters, func(quote *data.Quotes) error {
+		err := writer.Write(quote)
+		if err != nil {
+			return err
+		}
+		count++
+		if count%exportFlushEvery != 0 {
+			return nil
+		}
+		err = writer.Flush()
+		if err != nil {
+			return err
+		}
+		err = rc.Flush()
+		if err != nil {
+			return err
+		}
+		_ = rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+		return nil
+	})
+	switch {
+	// nothing reached the client yet (the CSV header is still
+	// buffered) so it can get a proper error
+	case err != nil && count == 0:
+		w.Header().Del("Content-Disposition")
+		a.serverErrorResponse(w, r, err)
+		return
+	case err == nil:
+		err = writer.Flush()
+	}
+	if err != nil {
+		// the status line went out with the first quote, all we can
+		// do is cut the connection so the client knows the file is
+		// incomplete
+		a.logError(r, err)
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,120 @@
+// Filename: cmd/api/export_internal_test.go
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Lee26Ed/qod/internal/data"
+)
+
+func TestExportQuotes(t *testing.T) {
+	app := newTestApplication(t)
+	handler := app.routes()
+
+	// more than the 100 quotes a page of /v1/quotes can hold
+	for i := 1; i <= 150; i++ {
+		author := "Seneca"
+		if i%2 == 0 {
+			author = "Epictetus"
+		}
+		quote := &data.Quotes{
+			Content: fmt.Sprintf("Stoic quote number %d", i),
+			Author:  author,
+			Tags:    []string{"stoic", "life"},
+		}
+		err := app.quoteModel.Insert(t.Context(), quote)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	auth := newTestUser(t, app, "reader@example.com", data.PermissionQuotesRead)
+	export := func(url string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, url, nil)
+		r.Header.Set("Authorization", auth["Authorization"])
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// the export is for the holders of quotes:read only
+	w, _ := sendRequest(t, handler, http.MethodGet, "/v1/quotes/export", "", nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d without a login, got %d", http.StatusUnauthorized, w.Code)
+	}
+	writer := newTestUser(t, app, "writer@example.com", data.PermissionQuotesWrite)
+	w, _ = sendRequest(t, handler, http.MethodGet, "/v1/quotes/export", "", writer)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status %d without quotes:read, got %d", http.StatusForbidden, w.Code)
+	}
+
+	w = export("/v1/quotes/export")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("jsonl: got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	scanner := bufio.NewScanner(w.Body)
+	lines := 0
+	for scanner.Scan() {
+		lines++
+		var quote data.Quotes
+		err := json.Unmarshal(scanner.Bytes(), &quote)
+		if err != nil {
+			t.Fatalf("line %d: %v", lines, err)
+		}
+		if quote.ID != int64(lines) {
+			t.Fatalf("line %d: expected quote %d, got %d", lines, lines, quote.ID)
+		}
+	}
+	if lines != 150 {
+		t.Errorf("jsonl: expected 150 quotes, got %d", lines)
+	}
+
+	// the filters and the sort of /v1/quotes apply
+	w = export("/v1/quotes/export?format=csv&author=seneca&sort=-id")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("csv: got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="quotes.csv"` {
+		t.Errorf("csv: unexpected Content-Disposition %q", got)
+	}
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 76 {
+		t.Fatalf("csv: expected a header and 75 quotes, got %d rows", len(records))
+	}
+	if strings.Join(records[0], ",") != "id,content,author,author_id,tags,created_by,version" {
+		t.Errorf("csv: unexpected header %q", records[0])
+	}
+	if records[1][0] != "149" || records[1][2] != "Seneca" || records[1][4] != "stoic,life" {
+		t.Errorf("csv: unexpected first quote %q", records[1])
+	}
+
+	for _, url := range []string{
+		"/v1/quotes/export?format=xml",
+		"/v1/quotes/export?sort=content",
+		"/v1/quotes/export?tags_mode=some",
+	} {
+		w := export(url)
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: expected 422, got %d", url, w.Code)
+		}
+	}
+	// the exports running now take every slot
+	for range cap(app.exports) {
+		app.exports <- struct{}{}
+	}
+	w = export("/v1/quotes/export")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("expected status %d with a Retry-After, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+}
@@ -0,0 +1,254 @@
+// Filename: cmd/api/feeds.go
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Lee26Ed/qod/internal/data"
+	"github.com/Lee26Ed/qod/internal/validator"
+)
+
+// how many of the newest quotes a feed carries
+const feedSize = 20
+
+// the newest quotes matching the filters and what the feed needs
+// to describe itself
+type quoteFeed struct {
+	selfURL string
+	siteURL string
+	updated time.Time
+	quotes  []*data.Quotes
+}
+
+// read the newest quotes for a feed. false means an error
+// response was already sent
+func (a *application) readQuoteFeed(w http.ResponseWriter, r *http.Request) (*quoteFeed, bool) {
+	v := validator.New()
+	search := a.readQuoteSearch(r.URL.Query(), v)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	filters := data.Filters{Page: 1, PageSize: feedSize, Sort: "-created_at", SortSafelist: quoteSortSafelist}
+	quotes, _, err := a.quoteModel.GetAll(r.Context(), search, filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	feed := &quoteFeed{
+		selfURL: a.baseURL() + r.URL.RequestURI(),
+		siteURL: a.baseURL() + "/v1/quotes",
+		quotes:  quotes,
+	}
+	// when one of its quotes was last added or updated
+	for _, quote := range quotes {
+		if quote.UpdatedAt.After(feed.updated) {
+			feed.updated = quote.UpdatedAt.UTC()
+		}
+	}
+	return feed, true
+}
+
+func (f *quoteFeed) quoteURL(quote *data.Quotes) string {
+	return fmt.Sprintf("%s/%d", f.siteURL, quote.ID)
+}
+
+func quoteTitle(quote *data.Quotes) string {
+	return fmt.Sprintf(`"%s" — %s`, quote.Content, quote.Author)
+}
+
+// GET /v1/feeds/quotes.rss?author=...&content=...&tags=...
+func (a *application) rssFeedHandler(w http.ResponseWriter, r *http.Request) {
+	feed, ok := a.readQuoteFeed(w, r)
+	if !ok {
+		return
+	}
+
+	channel := rssChannel{
+		Title:       "QOD: new quotes",
+		Link:        feed.siteURL,
+		Description: "The quotes most recently added to the collection",
+		AtomLink:    rssAtomLink{Href: feed.selfURL, Rel: "self", Type: "application/rss+xml"},
+		Items:       []rssItem{},
+	}
+	if !feed.updated.IsZero() {
+		channel.LastBuildDate = feed.updated.Format(time.RFC1123Z)
+	}
+	for _, quote := range feed.quotes {
+		channel.Items = append(channel.Items, rssItem{
+			Title:       quoteTitle(quote),
+			Link:        feed.quoteURL(quote),
+			GUID:        rssGUID{IsPermaLink: true, Value: feed.quoteURL(quote)},
+			PubDate:     quote.CreatedAt.UTC().Format(time.RFC1123Z),
+			Description: quote.Content,
+			Creator:     quote.Author,
+			Categories:  quote.Tags,
+		})
+	}
+
+	document := rssFeed{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		DCNS:    "http://purl.org/dc/elements/1.1/",
+		Channel: channel,
+	}
+	err := a.writeFeed(w, r, "application/rss+xml; charset=utf-8", document, feed.updated)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// GET /v1/feeds/quotes.atom?author=...&content=...&tags=...
+func (a *application) atomFeedHandler(w http.ResponseWriter, r *http.Request) {
+	feed, ok := a.readQuoteFeed(w, r)
+	if !ok {
+		return
+	}
+
+	// an Atom feed must say when it was updated, even when empty.
+	// With no quote to date it by we take the start of Unix time,
+	// the body (and so the ETag) stays the same from one request
+	// to the next
+	updated := feed.updated
+	if updated.IsZero() {
+		updated = time.Unix(0, 0).UTC()
+	}
+	document := atomFeed{
+		Title:   "QOD: new quotes",
+		ID:      feed.selfURL,
+		Updated: updated.Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: feed.selfURL, Rel: "self", Type: "application/atom+xml"},
+			{Href: feed.siteURL, Rel: "alternate", Type: "application/json"},
+		},
+		Entries: []atomEntry{},
+	}
+	for _, quote := range feed.quotes {
+		entry := atomEntry{
+			Title:     quoteTitle(quote),
+			ID:        feed.quoteURL(quote),
+			Link:      atomLink{Href: feed.quoteURL(quote), Rel: "alternate", Type: "application/json"},
+			Published: quote.CreatedAt.UTC().Format(time.RFC3339),
+			Updated:   quote.UpdatedAt.UTC().Format(time.RFC3339),
+			Author:    atomPerson{Name: quote.Author},
+			Content:   atomText{Type: "text", Body: quote.Content},
+		}
+		for _, tag := range quote.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: tag})
+		}
+		document.Entries = append(document.Entries, entry)
+	}
+
+	err := a.writeFeed(w, r, "application/atom+xml; charset=utf-8", document, feed.updated)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// write the feed document out, or a 304 when the client's copy is
+// current. The weak ETag comes from the document itself, so removing a
+// quote changes it as well. Last-Modified is when the newest quote was
+// added or updated: a client with only If-Modified-Since won't hear of
+// a deleted quote until the next change, one sending the ETag will
+func (a *application) writeFeed(w http.ResponseWriter, r *http.Request, contentType string, document any, updated time.Time) error {
+	body, err := xml.MarshalIndent(document, "", "\t")
+	if err != nil {
+		return err
+	}
+	body = append([]byte(xml.Header), body...)
+	body = append(body, '\n')
+
+	if a.notModified(w, r, weakETag(body), updated) {
+		return nil
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(body)
+	return err
+}
+
+// RSS 2.0 with the atom:link the validators ask for and
+// dc:creator for the author, which RSS wants to be an email
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	DCNS    string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string      `xml:"title"`
+	Link          string      `xml:"link"`
+	Description   string      `xml:"description"`
+	AtomLink      rssAtomLink `xml:"atom:link"`
+	LastBuildDate string      `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem   `xml:"item"`
+}
+
+type rssAtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Description string   `xml:"description"`
+	Creator     string   `xml:"dc:creator"`
+	Categories  []string `xml:"category"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// Atom 1.0 (RFC 4287)
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Link       atomLink       `xml:"link"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Author     atomPerson     `xml:"author"`
+	Content    atomText       `xml:"content"`
+	Categories []atomCategory `xml:"category"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
@@ -0,0 +1,156 @@
+// Filename: cmd/api/feeds_internal_test.go
+
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestQuoteFeeds(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.baseURL = "https://qod.example/"
+	handler := app.routes()
+	auth := newTestUser(t, app, "writer@example.com", "quotes:write")
+
+	sendRequest(t, handler, http.MethodPost, "/v1/quotes",
+		`{"content": "Know thyself", "author": "Socrates", "tags": ["wisdom"]}`, auth)
+	sendRequest(t, handler, http.MethodPost, "/v1/quotes",
+		`{"content": "Carpe diem", "author": "Horace"}`, auth)
+
+	get := func(url string, headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, url, nil)
+		for key, value := range headers {
+			r.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/v1/feeds/quotes.rss", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/rss+xml; charset=utf-8" {
+		t.Fatalf("rss: got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var rss struct {
+		Channel struct {
+			Items []struct {
+				Title string `xml:"title"`
+				GUID  string `xml:"guid"`
+			} `xml:"item"`
+		} `xml:"channel"`
+	}
+	err := xml.Unmarshal(w.Body.Bytes(), &rss)
+	if err != nil {
+		t.Fatalf("rss: %v\n%s", err, w.Body.String())
+	}
+	if len(rss.Channel.Items) != 2 {
+		t.Fatalf("rss: expected 2 items, got %d", len(rss.Channel.Items))
+	}
+	titles := []string{rss.Channel.Items[0].Title, rss.Channel.Items[1].Title}
+	if !slices.Contains(titles, `"Know thyself" — Socrates`) {
+		t.Errorf("rss: unexpected titles %q", titles)
+	}
+	for _, want := range []string{
+		`<atom:link href="https://qod.example/v1/feeds/quotes.rss" rel="self" type="application/rss+xml"></atom:link>`,
+		`<guid isPermaLink="true">https://qod.example/v1/quotes/1</guid>`,
+		`<dc:creator>Socrates</dc:creator>`,
+		`<category>wisdom</category>`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("rss: expected %q in\n%s", want, w.Body.String())
+		}
+	}
+
+	// the ETag changes with the feed, no sooner
+	etag := w.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("rss: expected a weak ETag, got %q", etag)
+	}
+	w = get("/v1/feeds/quotes.rss", map[string]string{"If-None-Match": etag})
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("rss: expected an empty 304, got %d", w.Code)
+	}
+
+	// the readers without the ETag go by Last-Modified
+	lastModified := w.Header().Get("Last-Modified")
+	if _, err := http.ParseTime(lastModified); err != nil {
+		t.Fatalf("rss: bad Last-Modified %q", lastModified)
+	}
+	w = get("/v1/feeds/quotes.rss", map[string]string{"If-Modified-Since": lastModified})
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("rss: expected an empty 304 for If-Modified-Since, got %d", w.Code)
+	}
+	w = get("/v1/feeds/quotes.rss", map[string]string{"If-Modified-Since": "Mon, 02 Jan 2006 15:04:05 GMT"})
+	if w.Code != http.StatusOK {
+		t.Errorf("rss: expected 200 for an older If-Modified-Since, got %d", w.Code)
+	}
+
+	// a deleted quote leaves Last-Modified where it was,
+	// the ETag tells the feed changed
+	sendRequest(t, handler, http.MethodDelete, "/v1/quotes/1", "", auth)
+	w = get("/v1/feeds/quotes.rss", map[string]string{"If-None-Match": etag, "If-Modified-Since": lastModified})
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "Know thyself") {
+		t.Errorf("rss: expected the feed without the deleted quote, got %d", w.Code)
+	}
+	sendRequest(t, handler, http.MethodPost, "/v1/quotes",
+		`{"content": "Know thyself", "author": "Socrates", "tags": ["wisdom"]}`, auth)
+
+	// the filters of /v1/quotes apply
+	w = get("/v1/feeds/quotes.atom?author=horace", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/atom+xml; charset=utf-8" {
+		t.Fatalf("atom: got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var atom struct {
+		XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+		ID      string   `xml:"id"`
+		Entries []struct {
+			ID      string `xml:"id"`
+			Author  string `xml:"author>name"`
+			Content string `xml:"content"`
+		} `xml:"entry"`
+	}
+	err = xml.Unmarshal(w.Body.Bytes(), &atom)
+	if err != nil {
+		t.Fatalf("atom: %v\n%s", err, w.Body.String())
+	}
+	if atom.ID != "https://qod.example/v1/feeds/quotes.atom?author=horace" {
+		t.Errorf("atom: unexpected feed id %q", atom.ID)
+	}
+	if len(atom.Entries) != 1 || atom.Entries[0].Author != "Horace" || atom.Entries[0].Content != "Carpe diem" ||
+		atom.Entries[0].ID != "https://qod.example/v1/quotes/2" {
+		t.Errorf("atom: unexpected entries %+v", atom.Entries)
+	}
+
+	// the links never come from the Host header
+	r := httptest.NewRequest(http.MethodGet, "/v1/feeds/quotes.atom", nil)
+	r.Host = "evil.example"
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if strings.Contains(w.Body.String(), "evil.example") {
+		t.Errorf("atom: expected no links to the request's host\n%s", w.Body.String())
+	}
+
+	// nothing matches, there is nothing to date the feed by
+	w = get("/v1/feeds/quotes.atom?author=plato", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Last-Modified") != "" {
+		t.Errorf("atom: expected 200 without Last-Modified, got %d %q", w.Code, w.Header().Get("Last-Modified"))
+	}
+	// but the empty feed is the same every time
+	if !strings.Contains(w.Body.String(), "<updated>1970-01-01T00:00:00Z</updated>") {
+		t.Errorf("atom: expected a fixed updated date in\n%s", w.Body.String())
+	}
+	w = get("/v1/feeds/quotes.atom?author=plato", map[string]string{"If-None-Match": w.Header().Get("ETag")})
+	if w.Code != http.StatusNotModified {
+		t.Errorf("atom: expected 304 for the empty feed, got %d", w.Code)
+	}
+
+	w = get("/v1/feeds/quotes.rss?tags_mode=some", nil)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("rss: expected 422 for a bad filter, got %d", w.Code)
+	}
+}
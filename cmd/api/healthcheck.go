@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"time"
 )
 
+// the longest the readiness check waits for PostgreSQL
+const readinessTimeout = 2 * time.Second
+
 func (app *application) healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	// panic("apples and oranges")
 	data := envelope {
@@ -18,4 +23,93 @@ func (app *application) healthCheckHandler(w http.ResponseWriter, r *http.Reques
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
-}
\ No newline at end of file
+}
+
+// GET /v1/healthcheck/live
+// the process is up and serving requests. Nothing else is checked so
+// a database outage doesn't get us restarted
+func (app *application) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	data := envelope{
+		"status": "alive",
+		"system_info": map[string]string{
+			"environment": app.config.env,
+			"version":     version,
+		},
+	}
+
+	err := app.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// GET /v1/healthcheck/ready
+// we can take traffic: the database answers and we are not shutting
+// down. Anything else is a 503 so the load balancer sends the
+// requests to another replica
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
+	data := envelope{
+		"status": "ready",
+	}
+
+	if app.shuttingDown.Load() {
+		status = http.StatusServiceUnavailable
+		data["status"] = "shutting down"
+	}
+
+	if app.db == nil {
+		data["database"] = map[string]any{"status": "not used"}
+	} else {
+		database, err := app.databaseReadiness(r.Context())
+		if err != nil {
+			// the details go to the logs, not to whoever is asking
+			app.logError(r, err)
+			status = http.StatusServiceUnavailable
+			data["status"] = "unavailable"
+		}
+		data["database"] = database
+	}
+
+	err := app.writeJSON(w, status, data, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// ping the database and describe the pool and the schema
+func (app *application) databaseReadiness(ctx context.Context) (map[string]any, error) {
+	stats := app.db.Stats()
+	database := map[string]any{
+		"status": "available",
+		"pool": map[string]any{
+			"max_open":      stats.MaxOpenConnections,
+			"open":          stats.OpenConnections,
+			"in_use":        stats.InUse,
+			"idle":          stats.Idle,
+			"wait_count":    stats.WaitCount,
+			"wait_duration": stats.WaitDuration.String(),
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	err := app.db.PingContext(ctx)
+	if err != nil {
+		database["status"] = "unavailable"
+		return database, err
+	}
+
+	migrationVersion, dirty, err := app.migrator.Version(ctx)
+	if err != nil {
+		database["status"] = "unavailable"
+		return database, err
+	}
+	database["migration"] = map[string]any{
+		"version": migrationVersion,
+		"dirty":   dirty,
+		"latest":  app.migrator.Latest(),
+	}
+	return database, nil
+}
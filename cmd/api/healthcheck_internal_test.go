@@ -0,0 +1,40 @@
+// Filename: cmd/api/healthcheck_internal_test.go
+
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestHealthChecks(t *testing.T) {
+	app := newTestApplication(t)
+	handler := app.routes()
+
+	w, body := sendRequest(t, handler, http.MethodGet, "/v1/healthcheck/live", "", nil)
+	if w.Code != http.StatusOK || body["status"] != "alive" {
+		t.Fatalf("live: got %d %v", w.Code, body)
+	}
+
+	// the in-memory store has no database to ping
+	w, body = sendRequest(t, handler, http.MethodGet, "/v1/healthcheck/ready", "", nil)
+	if w.Code != http.StatusOK || body["status"] != "ready" {
+		t.Fatalf("ready: got %d %v", w.Code, body)
+	}
+	database, _ := body["database"].(map[string]any)
+	if database["status"] != "not used" {
+		t.Errorf("ready: expected the database to be unused, got %v", body["database"])
+	}
+
+	// once shutdown starts we are still alive but no longer ready
+	app.shuttingDown.Store(true)
+
+	w, body = sendRequest(t, handler, http.MethodGet, "/v1/healthcheck/ready", "", nil)
+	if w.Code != http.StatusServiceUnavailable || body["status"] != "shutting down" {
+		t.Errorf("ready while shutting down: got %d %v", w.Code, body)
+	}
+	w, _ = sendRequest(t, handler, http.MethodGet, "/v1/healthcheck/live", "", nil)
+	if w.Code != http.StatusOK {
+		t.Errorf("live while shutting down: got %d", w.Code)
+	}
+}
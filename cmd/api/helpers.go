@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,7 +11,9 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Lee26Ed/qod/internal/data"
 	"github.com/Lee26Ed/qod/internal/validator"
 	"github.com/julienschmidt/httprouter"
 )
@@ -19,12 +23,11 @@ type envelope map[string]any
 
 
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
-	jsResponse, err := json.MarshalIndent(data, "", "\t")
+	jsResponse, err := marshalJSON(data)
     if err != nil {
         return err
     }
 
-	jsResponse = append(jsResponse, '\n')
     // additional headers to be set
     for key, value := range headers {
         w.Header()[key] = value
@@ -41,6 +44,15 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
     return nil
 }
 
+// the indented JSON of a response body
+func marshalJSON(data envelope) ([]byte, error) {
+	jsResponse, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append(jsResponse, '\n'), nil
+}
+
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, destination any) error {
 	// what is the max size of the request body (250KB seems reasonable)
     maxBytes := 256_000
@@ -123,6 +135,31 @@ func (a *application)getSingleQueryParameter(
     return result                                                                      
 }
 
+// read the search filters shared by the endpoints that look through
+// the quotes (?content=...&author=...&tags=courage,life&tags_mode=all)
+func (a *application) readQuoteSearch(queryParameters url.Values, v *validator.Validator) data.QuoteSearch {
+	tagsMode := a.getSingleQueryParameter(queryParameters, "tags_mode", "any")
+	v.Check(validator.PermittedValue(tagsMode, "any", "all"), "tags_mode", "must be any or all")
+
+	return data.QuoteSearch{
+		Content:      a.getSingleQueryParameter(queryParameters, "content", ""),
+		Author:       a.getSingleQueryParameter(queryParameters, "author", ""),
+		Tags:         a.getMultipleQueryParameters(queryParameters, "tags", []string{}),
+		MatchAllTags: tagsMode == "all",
+	}
+}
+
+// read the pagination and sorting parameters of a listing
+// (?page=2&page_size=20&sort=-created_at)
+func (a *application) readFilters(queryParameters url.Values, defaultSort string, sortSafelist []string, v *validator.Validator) data.Filters {
+	return data.Filters{
+		Page:         a.getSingleIntegerParameter(queryParameters, "page", 1, v),
+		PageSize:     a.getSingleIntegerParameter(queryParameters, "page_size", 20, v),
+		Sort:         a.getSingleQueryParameter(queryParameters, "sort", defaultSort),
+		SortSafelist: sortSafelist,
+	}
+}
+
 // call when we have multiple comma-separated values
 func (a *application)getMultipleQueryParameters( 
                                  queryParameters url.Values,
@@ -157,3 +194,150 @@ func (a *application)getSingleIntegerParameter(
 
    return intValue
 }
+
+// the strong ETag of a quote. Every update bumps the version so
+// the tag changes whenever the quote does
+func quoteETag(quote *data.Quotes) string {
+	return fmt.Sprintf(`"%d-%d"`, quote.ID, quote.Version)
+}
+
+// the same quote as CSV or XML is a different representation
+// and needs its own strong ETag, "3-2" becomes "3-2-csv"
+func representationETag(etag string, format responseFormat) string {
+	if format.name == "json" || strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + format.name + `"`
+}
+
+// a weak ETag for responses without a version of their own (the
+// listings), taken from the bytes of the body
+func weakETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// does the If-Match or If-None-Match header list one of the etags.
+// The weak comparison ignores the W/ prefix, the strong one
+// never matches a weak tag
+func etagMatches(header string, etags []string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		for _, etag := range etags {
+			if weak {
+				if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+					return true
+				}
+				continue
+			}
+			if candidate == etag && !strings.HasPrefix(etag, "W/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// the clients from before the ETags send the bare version they read
+// in If-Match, 3 or "3". found is false for anything else
+func readIfMatchVersion(r *http.Request) (int32, bool) {
+	value := strings.Trim(strings.TrimSpace(r.Header.Get("If-Match")), `"`)
+	version, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return int32(version), true
+}
+
+// check the If-Match and If-Unmodified-Since headers of a PATCH or
+// DELETE against the quote the client wants to change. false means the
+// client's copy is out of date and a 412 was sent. A bare version in
+// If-Match keeps working the way it always has: a 409 when it isn't
+// the version of the quote
+func (a *application) checkQuotePreconditions(w http.ResponseWriter, r *http.Request, quote *data.Quotes) bool {
+	if version, found := readIfMatchVersion(r); found {
+		if version != quote.Version {
+			a.editConflictResponse(w, r)
+			return false
+		}
+		return true
+	}
+
+	// the tags of every representation the client may have read
+	etags := []string{}
+	for _, format := range responseFormats {
+		etags = append(etags, representationETag(quoteETag(quote), format))
+	}
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !etagMatches(ifMatch, etags, false) {
+			a.preconditionFailedResponse(w, r)
+			return false
+		}
+		return true
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Unmodified-Since"))
+	if err == nil && quote.UpdatedAt.UTC().Truncate(time.Second).After(since) {
+		a.preconditionFailedResponse(w, r)
+		return false
+	}
+	return true
+}
+
+// send the ETag and Last-Modified (either may be empty) and check them
+// against the If-None-Match or If-Modified-Since of the request. true
+// means the client's copy is current and a 304 was sent
+func (a *application) notModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !lastModified.IsZero() {
+		// HTTP dates don't go below a second
+		lastModified = lastModified.UTC().Truncate(time.Second)
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	// If-None-Match wins, If-Modified-Since is only for
+	// clients that have no ETag
+	current := false
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		current = etag != "" && etagMatches(ifNoneMatch, []string{etag}, true)
+	} else if !lastModified.IsZero() {
+		since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		current = err == nil && !lastModified.After(since)
+	}
+	if !current {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// where the clients reach the API, for the absolute URLs of the feeds.
+// Always -base-url: the Host header is up to the client and the
+// feeds end up in caches
+func (a *application) baseURL() string {
+	return strings.TrimSuffix(a.config.baseURL, "/")
+}
+
+// run fn in a goroutine that Serve waits for when shutting down.
+// A panic in fn is logged instead of taking the server down
+func (a *application) background(fn func()) {
+	a.wg.Go(func() {
+		defer func() {
+			err := recover()
+			if err != nil {
+				a.logger.Error(fmt.Sprintf("%v", err))
+			}
+		}()
+		fn()
+	})
+}
@@ -0,0 +1,232 @@
+// Filename: cmd/api/metrics.go
+package main
+
+import (
+	"cmp"
+	"database/sql"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// the upper bounds (in seconds) of the latency histogram buckets,
+// the same as the Prometheus client libraries use
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metrics counts what goes through the API. The counters are expvar
+// values so /debug/vars can show them, /metrics writes the same
+// numbers in the Prometheus text format. Neither is part of the public
+// API, they are served on the -metrics-addr listener (see metricsRoutes)
+type metrics struct {
+	totalRequests       expvar.Int
+	totalResponses      expvar.Map // by status code
+	inFlight            expvar.Int
+	rateLimitRejections expvar.Int
+
+	mu      sync.Mutex
+	latency map[routeKey]*histogram
+}
+
+// the route pattern (not the path) keeps the number of series small
+type routeKey struct {
+	method string
+	route  string
+}
+
+// the number of observations that fell in each bucket (not cumulative)
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newMetrics() *metrics {
+	m := &metrics{latency: make(map[routeKey]*histogram)}
+	m.totalResponses.Init()
+	return m
+}
+
+// record one finished request
+func (m *metrics) observe(method string, route string, status int, duration time.Duration) {
+	m.totalResponses.Add(strconv.Itoa(status), 1)
+
+	key := routeKey{method: method, route: route}
+	seconds := duration.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, found := m.latency[key]
+	if !found {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.latency[key] = h
+	}
+	// values above the last bound only show up in the +Inf bucket
+	i, _ := slices.BinarySearch(latencyBuckets, seconds)
+	if i < len(latencyBuckets) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// the histograms sorted by route so the output is stable
+func (m *metrics) latencySnapshot() ([]routeKey, map[routeKey]histogram) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]routeKey, 0, len(m.latency))
+	snapshot := make(map[routeKey]histogram, len(m.latency))
+	for key, h := range m.latency {
+		keys = append(keys, key)
+		snapshot[key] = histogram{counts: slices.Clone(h.counts), count: h.count, sum: h.sum}
+	}
+	slices.SortFunc(keys, func(a, b routeKey) int {
+		return cmp.Or(cmp.Compare(a.route, b.route), cmp.Compare(a.method, b.method))
+	})
+	return keys, snapshot
+}
+
+// publish makes the metrics part of /debug/vars. expvar names are
+// global to the process so this is only called once, from main()
+func (m *metrics) publish(db *sql.DB) {
+	expvar.Publish("total_requests_received", &m.totalRequests)
+	expvar.Publish("total_responses_sent_by_status", &m.totalResponses)
+	expvar.Publish("in_flight_requests", &m.inFlight)
+	expvar.Publish("rate_limit_rejections", &m.rateLimitRejections)
+	expvar.Publish("request_latency_seconds", expvar.Func(func() any {
+		keys, snapshot := m.latencySnapshot()
+		routes := make(map[string]any, len(keys))
+		for _, key := range keys {
+			h := snapshot[key]
+			routes[key.method+" "+key.route] = map[string]any{
+				"count": h.count,
+				"sum":   h.sum,
+			}
+		}
+		return routes
+	}))
+	expvar.Publish("goroutines", expvar.Func(func() any {
+		return runtime.NumGoroutine()
+	}))
+	if db != nil {
+		expvar.Publish("database", expvar.Func(func() any {
+			return db.Stats()
+		}))
+	}
+}
+
+// the routes of the internal -metrics-addr listener. Keep it off the
+// internet: the numbers say a lot about the API and its database
+func (a *application) metricsRoutes() http.Handler {
+	router := httprouter.New()
+	router.HandlerFunc(http.MethodGet, "/metrics", a.metricsHandler)
+	router.HandlerFunc(http.MethodGet, "/debug/vars", debugVarsHandler)
+	return router
+}
+
+// GET /debug/vars
+// what expvar.Handler() writes, minus the command line expvar publishes
+// on its own. Ours holds the -db-dsn and -smtp-password values
+func debugVarsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintf(w, "{\n")
+	first := true
+	expvar.Do(func(kv expvar.KeyValue) {
+		if kv.Key == "cmdline" {
+			return
+		}
+		if !first {
+			fmt.Fprintf(w, ",\n")
+		}
+		first = false
+		fmt.Fprintf(w, "%q: %s", kv.Key, kv.Value)
+	})
+	fmt.Fprintf(w, "\n}\n")
+}
+
+// GET /metrics
+// the metrics in the Prometheus text exposition format
+func (a *application) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	a.metrics.writePrometheus(w, a.db)
+}
+
+func (m *metrics) writePrometheus(w io.Writer, db *sql.DB) {
+	family := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	family("qod_http_requests_total", "counter", "HTTP requests received.")
+	fmt.Fprintf(w, "qod_http_requests_total %d\n", m.totalRequests.Value())
+
+	family("qod_http_responses_total", "counter", "HTTP responses sent by status code.")
+	m.totalResponses.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(w, "qod_http_responses_total{code=%q} %s\n", kv.Key, kv.Value)
+	})
+
+	family("qod_http_requests_in_flight", "gauge", "HTTP requests being served.")
+	fmt.Fprintf(w, "qod_http_requests_in_flight %d\n", m.inFlight.Value())
+
+	family("qod_rate_limit_rejections_total", "counter", "Requests refused by the rate limiter.")
+	fmt.Fprintf(w, "qod_rate_limit_rejections_total %d\n", m.rateLimitRejections.Value())
+
+	family("qod_http_request_duration_seconds", "histogram", "HTTP request latency by route pattern.")
+	keys, snapshot := m.latencySnapshot()
+	for _, key := range keys {
+		h := snapshot[key]
+		labels := fmt.Sprintf("method=%q,route=%q", key.method, key.route)
+
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "qod_http_request_duration_seconds_bucket{%s,le=%q} %d\n",
+				labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "qod_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(w, "qod_http_request_duration_seconds_sum{%s} %g\n", labels, h.sum)
+		fmt.Fprintf(w, "qod_http_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	// nothing to report with -storage=memory
+	if db == nil {
+		return
+	}
+	stats := db.Stats()
+	for _, gauge := range []struct {
+		name  string
+		help  string
+		value int
+	}{
+		{"qod_db_max_open_connections", "Maximum number of open connections to the database.", stats.MaxOpenConnections},
+		{"qod_db_open_connections", "Established connections, both in use and idle.", stats.OpenConnections},
+		{"qod_db_in_use_connections", "Connections currently in use.", stats.InUse},
+		{"qod_db_idle_connections", "Idle connections.", stats.Idle},
+	} {
+		family(gauge.name, "gauge", gauge.help)
+		fmt.Fprintf(w, "%s %d\n", gauge.name, gauge.value)
+	}
+	for _, counter := range []struct {
+		name  string
+		help  string
+		value int64
+	}{
+		{"qod_db_wait_count_total", "Connections waited for.", stats.WaitCount},
+		{"qod_db_max_idle_closed_total", "Connections closed due to SetMaxIdleConns.", stats.MaxIdleClosed},
+		{"qod_db_max_idle_time_closed_total", "Connections closed due to SetConnMaxIdleTime.", stats.MaxIdleTimeClosed},
+		{"qod_db_max_lifetime_closed_total", "Connections closed due to SetConnMaxLifetime.", stats.MaxLifetimeClosed},
+	} {
+		family(counter.name, "counter", counter.help)
+		fmt.Fprintf(w, "%s %d\n", counter.name, counter.value)
+	}
+	family("qod_db_wait_duration_seconds_total", "counter", "Time spent waiting for a connection.")
+	fmt.Fprintf(w, "qod_db_wait_duration_seconds_total %g\n", stats.WaitDuration.Seconds())
+}
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
-	"net"
+	"math"
 	"net/http"
-	"sync"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
-	"golang.org/x/time/rate"
+	"github.com/Lee26Ed/qod/internal/data"
+	"github.com/Lee26Ed/qod/internal/ratelimit"
+	"github.com/Lee26Ed/qod/internal/validator"
 )
 
 func (a *application) recoverPanic(next http.Handler) http.Handler {
@@ -16,6 +23,10 @@ func (a *application) recoverPanic(next http.Handler) http.Handler {
        defer func() {
            // recover() checks for panics
            err := recover();
+           // a handler giving up on a response it already started
+           if err == http.ErrAbortHandler {
+               panic(err)
+           }
            if err != nil {
                w.Header().Set("Connection", "close")
                a.serverErrorResponse(w, r, fmt.Errorf("%s", err))
@@ -25,76 +36,532 @@ func (a *application) recoverPanic(next http.Handler) http.Handler {
    })  
 }
 
+// Work out the client's address once, behind a trusted proxy it comes
+// from the forwarding headers, and keep it in the request context
+func (a *application) realIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = a.contextSetClientIP(r, clientIP(r, a.config.trustedProxies, a.config.proxyHeader))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Give every request an ID, the one the client (or a proxy in front of
+// us) sent in X-Request-ID when it looks sane, a new one otherwise.
+// It goes back in the response and into the logs and error envelopes
+func (a *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = rand.Text()
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		r = a.contextSetRequestInfo(r, &requestInfo{id: id})
+		next.ServeHTTP(w, r)
+	})
+}
+
+// we log the IDs we are given so only accept
+// short ones made of a few safe characters
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.ContainsRune("-_.:", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Write one access log line per request once it's done
+func (a *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		mw := &recordingResponseWriter{wrapped: w, statusCode: http.StatusOK}
+		next.ServeHTTP(mw, r)
+
+		var requestID, route string
+		var userID int64
+		if info := a.contextGetRequestInfo(r); info != nil {
+			requestID, route, userID = info.id, info.route, info.userID
+		}
+
+		a.logger.Info("request",
+			"request_id", requestID,
+			"method", r.Method,
+			"route", route,
+			"uri", r.URL.RequestURI(),
+			"status", mw.statusCode,
+			"bytes", mw.bytes,
+			"duration", time.Since(start),
+			"client_ip", a.contextGetClientIP(r),
+			"user_id", userID,
+		)
+	})
+}
+
+// Count the requests, their status codes and how long each
+// route pattern takes. The in-flight gauge goes up and down
+// around the rest of the chain
+func (a *application) collectMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		a.metrics.totalRequests.Add(1)
+		a.metrics.inFlight.Add(1)
+		defer a.metrics.inFlight.Add(-1)
+
+		info := a.contextGetRequestInfo(r)
+		if info == nil {
+			info = &requestInfo{}
+			r = a.contextSetRequestInfo(r, info)
+		}
+
+		mw := &recordingResponseWriter{wrapped: w, statusCode: http.StatusOK}
+		next.ServeHTTP(mw, r)
+
+		// the 404s and 405s never reach a route
+		route := info.route
+		if route == "" {
+			route = "unmatched"
+		}
+		a.metrics.observe(r.Method, route, mw.statusCode, time.Since(start))
+	})
+}
+
+// recordingResponseWriter remembers the status code and the size of
+// the response on its way out, for the access log and the metrics
+type recordingResponseWriter struct {
+	wrapped       http.ResponseWriter
+	statusCode    int
+	headerWritten bool
+	bytes         int
+}
+
+func (mw *recordingResponseWriter) Header() http.Header {
+	return mw.wrapped.Header()
+}
+
+func (mw *recordingResponseWriter) WriteHeader(statusCode int) {
+	mw.wrapped.WriteHeader(statusCode)
+	if !mw.headerWritten {
+		mw.statusCode = statusCode
+		mw.headerWritten = true
+	}
+}
+
+func (mw *recordingResponseWriter) Write(b []byte) (int, error) {
+	mw.headerWritten = true
+	n, err := mw.wrapped.Write(b)
+	mw.bytes += n
+	return n, err
+}
+
+// lets http.ResponseController reach Flush() and friends
+func (mw *recordingResponseWriter) Unwrap() http.ResponseWriter {
+	return mw.wrapped
+}
+
+// Look up the key of an X-API-Key header and put it in the request
+// context so the rate limiter can use its tier. An unknown key is not
+// rejected here, authenticate does that once the limiter had its say
+func (a *application) resolveAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		plaintext := r.Header.Get("X-API-Key")
+		if plaintext == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		v := validator.New()
+		data.ValidateAPIKeyPlaintext(v, plaintext)
+		if !v.IsEmpty() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, err := a.apiKeyModel.GetByPlaintext(r.Context(), plaintext)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				next.ServeHTTP(w, r)
+			default:
+				a.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		r = a.contextSetAPIKey(r, key)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Work out who is calling from the Authorization: Bearer <token> header,
+// or the owner of the X-API-Key, and put the user in the request context.
+// Requests without either header carry on as the AnonymousUser
+func (a *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the response depends on the credentials
+		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "X-API-Key")
+
+		authorization := r.Header.Get("Authorization")
+		if r.Header.Get("X-API-Key") != "" {
+			if authorization != "" {
+				a.badRequestResponse(w, r, errors.New("send either an Authorization or an X-API-Key header, not both"))
+				return
+			}
+			a.authenticateAPIKey(w, r, next)
+			return
+		}
+
+		if authorization == "" {
+			r = a.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := bearerToken(r)
+		if !ok {
+			a.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		v := validator.New()
+		data.ValidateTokenPlaintext(v, token)
+		if !v.IsEmpty() {
+			a.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		user, err := a.userModel.GetForToken(r.Context(), data.ScopeAuthentication, token)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				a.invalidAuthenticationTokenResponse(w, r)
+			default:
+				a.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		r = a.contextSetUser(r, user)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// the caller is the owner of the API key resolveAPIKey found
+func (a *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	key := a.contextGetAPIKey(r)
+	if key == nil {
+		a.invalidAPIKeyResponse(w, r)
+		return
+	}
+
+	user, err := a.userModel.Get(r.Context(), key.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.invalidAPIKeyResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	r = a.contextSetUser(r, user)
+	next.ServeHTTP(w, r)
+}
+
+// the token of an "Authorization: Bearer <token>" header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// only let logged in users through
+func (a *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := a.contextGetUser(r)
+		if user.IsAnonymous() {
+			a.authenticationRequiredResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// only let users who activated their account through
+func (a *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user := a.contextGetUser(r)
+		if !user.Activated {
+			a.inactiveAccountResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return a.requireAuthenticatedUser(fn)
+}
+
+// only let activated users holding the permission code through.
+// Anonymous callers get a 401, logged in ones without the code a 403
+func (a *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user := a.contextGetUser(r)
+
+		permissions, err := a.permissionModel.GetAllForUser(r.Context(), user.ID)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+		if !permissions.Include(code) {
+			a.notPermittedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return a.requireActivatedUser(fn)
+}
+
+// Users may change the quotes they created. Changing anyone
+// else's quote takes the quotes:admin permission
+func (a *application) canModifyQuote(r *http.Request, quote *data.Quotes) (bool, error) {
+	user := a.contextGetUser(r)
+	if quote.CreatedBy != 0 && quote.CreatedBy == user.ID {
+		return true, nil
+	}
+
+	permissions, err := a.permissionModel.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		return false, err
+	}
+	return permissions.Include(data.PermissionQuotesAdmin), nil
+}
+
+// Only the quotes and the accounts have an in-memory store. The other
+// resources live in PostgreSQL so with -storage=memory their routes
+// answer 501 instead of reaching for a connection pool we don't have
+func (a *application) requireDatabase(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.db == nil {
+			a.databaseRequiredResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// the methods and request headers a browser may use on a cross-origin request
+const (
+	corsAllowedMethods = "OPTIONS, GET, POST, PUT, PATCH, DELETE"
+	corsAllowedHeaders = "Authorization, Content-Type, If-Match, If-Modified-Since, If-None-Match, If-Unmodified-Since, X-API-Key, X-Request-ID"
+)
+
+// the response headers the browser lets a cross-origin script read
+// on top of the CORS-safelisted ones
+var corsExposedHeaders = strings.Join([]string{
+	"ETag",
+	"Location",
+	"RateLimit-Limit",
+	"RateLimit-Remaining",
+	"RateLimit-Reset",
+	"Retry-After",
+	"X-Request-ID",
+}, ", ")
+
+// Let the trusted origins call us from a browser. A preflight
+// request (OPTIONS with Access-Control-Request-Method) is answered
+// right here with what the real request may do
 func (a *application) enableCORS (next http.Handler) http.Handler {                             
    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
 		// Let's check the request origin to see if it's in the trusted list
 		origin := r.Header.Get("Origin")
 		
 		// Once we have a origin from the request header we need need to check
-		if origin != "" {
-			for i := range a.config.cors.trustedOrigins {
-				if origin == a.config.cors.trustedOrigins[i] {
-				w.Header().Set("Access-Control-Allow-Origin", origin)                                          
-				break
-				}
-		}
-		}
+		if origin != "" && originAllowed(origin, a.config.cors.trustedOrigins) {
+			// the origin is echoed, never "*", so credentials can be allowed
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if a.config.cors.allowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
 
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+				w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(a.config.cors.maxAge.Seconds())))
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		}
 
         next.ServeHTTP(w, r)
     })
 	
 }
 
-func (a *application) rateLimit(next http.Handler) http.Handler {
-	type client struct {
-		limiter *rate.Limiter
-		lastSeen time.Time
+// With credentials allowed, "*" would let any site make requests as the
+// logged in user and read the answers. We refuse to start like that
+func checkCORSOrigins(patterns []string, allowCredentials bool) error {
+	if allowCredentials && slices.Contains(patterns, "*") {
+		return errors.New("-cors-trusted-origins=* can't be used with -cors-allow-credentials, list the origins instead")
 	}
+	return nil
+}
 
-	var mu sync.Mutex
-	var clients = make(map[string] *client)
-
-	go func() {
-		for {
-			time.Sleep(time.Minute)
-			mu.Lock()
-
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3 * time.Minute {
-					delete(clients, ip)
-				}
+// An origin is trusted when it matches one of the patterns: an exact
+// origin ("https://qod.example"), "*" for any origin, or a "*" standing
+// for the subdomains of a host ("https://*.qod.example")
+func originAllowed(origin string, patterns []string) bool {
+	for _, pattern := range patterns {
+		prefix, suffix, wildcard := strings.Cut(pattern, "*")
+		switch {
+		case !wildcard:
+			if origin == pattern {
+				return true
+			}
+		case pattern == "*":
+			return true
+		default:
+			if len(origin) <= len(prefix)+len(suffix) ||
+				!strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+				continue
+			}
+			// the * only covers host name labels
+			subdomain := origin[len(prefix) : len(origin)-len(suffix)]
+			if !strings.ContainsAny(subdomain, "/:@") {
+				return true
 			}
-			mu.Unlock()
 		}
-	}()
+	}
+	return false
+}
 
+// Limit how fast each client may call us, before we do any work for
+// it. Requests without an API key are limited per IP address at
+// -limiter-rps/-limiter-burst. Looking a key up costs a query, so the
+// requests with one first go through a bucket per IP address at the
+// rate of the highest tier: a client guessing keys can't keep the
+// database busy, a real key is never held back by it. Its own limit
+// follows in rateLimitAPIKey once we know who it belongs to
+func (a *application) rateLimit(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if a.config.limiter.enabled {
-		ip, _, err := net.SplitHostPort(r.RemoteAddr)
-		if err != nil {
-			a.serverErrorResponse(w, r, err)
+		if !a.config.limiter.enabled {
+			next.ServeHTTP(w, r)
 			return
 		}
-		
-		mu.Lock()
 
-		_, found := clients[ip]
-		if !found {
-		clients[ip] = &client{limiter: rate.NewLimiter(rate.Limit(a.config.limiter.rps), a.config.limiter.burst)}
+		key := "ip:" + a.contextGetClientIP(r)
+		limit := ratelimit.Rate{RPS: a.config.limiter.rps, Burst: a.config.limiter.burst}
+
+		if r.Header.Get("X-API-Key") != "" {
+			key = "lookup:" + a.contextGetClientIP(r)
+			limit = apiKeyLookupRate()
 		}
 
-		clients[ip].lastSeen = time.Now()
+		if a.allowRequest(w, r, key, limit) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
 
-		if !clients[ip].limiter.Allow() {
-			mu.Unlock()
-			a.rateLimitExceededResponse(w, r)
+// The requests with a valid API key are limited per user at the rate
+// of the key's tier, however many keys the user holds. An unknown or
+// revoked key counts against the IP address like no key at all
+func (a *application) rateLimitAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.config.limiter.enabled || r.Header.Get("X-API-Key") == "" {
+			next.ServeHTTP(w, r)
 			return
 		}
-	
-		mu.Unlock()
+
+		key := "ip:" + a.contextGetClientIP(r)
+		limit := ratelimit.Rate{RPS: a.config.limiter.rps, Burst: a.config.limiter.burst}
+
+		if apiKey := a.contextGetAPIKey(r); apiKey != nil {
+			tier := data.APIKeyTiers[apiKey.Tier]
+			// the user's keys on a tier share a bucket, one on
+			// another tier (or moved to it) starts its own
+			key = fmt.Sprintf("user:%d:%s", apiKey.UserID, apiKey.Tier)
+			limit = ratelimit.Rate{RPS: tier.RPS, Burst: tier.Burst}
+		}
+
+		if a.allowRequest(w, r, key, limit) {
+			next.ServeHTTP(w, r)
 		}
-		next.ServeHTTP(w, r)
 	})
-}
\ No newline at end of file
+}
+
+// take one request from the key's bucket. When it is empty (or the
+// limiter fails) the response has been sent and we return false
+func (a *application) allowRequest(w http.ResponseWriter, r *http.Request, key string, limit ratelimit.Rate) bool {
+	result, err := a.limiter.Allow(r.Context(), key, limit)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	setRateLimitHeaders(w.Header(), result)
+	if !result.Allowed {
+		a.metrics.rateLimitRejections.Add(1)
+		a.rateLimitExceededResponse(w, r)
+		return false
+	}
+	return true
+}
+
+// the rate of the highest API key tier
+func apiKeyLookupRate() ratelimit.Rate {
+	var limit ratelimit.Rate
+	for _, tier := range data.APIKeyTiers {
+		limit.RPS = max(limit.RPS, tier.RPS)
+		limit.Burst = max(limit.Burst, tier.Burst)
+	}
+	return limit
+}
+
+// Report the quota like the IETF RateLimit header fields draft:
+// the burst, the requests left right now and the seconds until the
+// bucket is full again. A client that ran out also gets Retry-After,
+// the seconds until the next request is allowed
+func setRateLimitHeaders(header http.Header, result ratelimit.Result) {
+	header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+
+	if !result.Allowed && result.RetryAfter > 0 {
+		header.Set("Retry-After", strconv.Itoa(max(int(math.Ceil(result.RetryAfter.Seconds())), 1)))
+	}
+}
+
+// forget the clients the limiter hasn't seen for a while,
+// every interval until ctx is cancelled
+func (a *application) pruneRateLimits(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := a.limiter.Prune(ctx, 3*time.Minute)
+			if err != nil {
+				a.logger.Error("pruning the rate limiter", "error", err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,276 @@
+// Filename: cmd/api/middleware_internal_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Lee26Ed/qod/internal/data"
+)
+
+func TestAuthenticate(t *testing.T) {
+	handler := newTestApplication(t).routes()
+
+	tests := []struct {
+		name          string
+		method        string
+		url           string
+		authorization string
+		status        int
+	}{
+		{"anonymous read", http.MethodGet, "/v1/quotes", "", http.StatusOK},
+		{"wrong scheme", http.MethodGet, "/v1/quotes", "Basic dXNlcjpwYXNz", http.StatusUnauthorized},
+		{"malformed token", http.MethodGet, "/v1/quotes", "Bearer abc", http.StatusUnauthorized},
+		{"logout needs a token", http.MethodDelete, "/v1/tokens/authentication", "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{}
+			if tt.authorization != "" {
+				headers["Authorization"] = tt.authorization
+			}
+			w, _ := sendRequest(t, handler, tt.method, tt.url, "", headers)
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, w.Code)
+			}
+			if tt.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("expected a WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	app := newTestApplication(t)
+	handler := app.routes()
+
+	owner := newTestUser(t, app, "owner@example.com", data.PermissionQuotesRead, data.PermissionQuotesWrite)
+	other := newTestUser(t, app, "other@example.com", data.PermissionQuotesRead, data.PermissionQuotesWrite)
+	reader := newTestUser(t, app, "reader@example.com", data.PermissionQuotesRead)
+	admin := newTestUser(t, app, "admin@example.com", data.PermissionQuotesWrite, data.PermissionQuotesAdmin)
+
+	w, body := sendRequest(t, handler, http.MethodPost, "/v1/quotes",
+		`{"content": "Know thyself", "author": "Socrates"}`, owner)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, w.Code)
+	}
+	if got := body["quote"].(map[string]any)["created_by"]; got != float64(1) {
+		t.Errorf("expected created_by 1, got %v", got)
+	}
+
+	tomorrow := time.Now().UTC().AddDate(0, 0, 1).Format(data.DayLayout)
+
+	tests := []struct {
+		name    string
+		method  string
+		url     string
+		body    string
+		headers map[string]string
+		status  int
+	}{
+		{"anonymous create", http.MethodPost, "/v1/quotes", `{"content": "hi", "author": "me"}`, nil, http.StatusUnauthorized},
+		{"create without quotes:write", http.MethodPost, "/v1/quotes", `{"content": "hi", "author": "me"}`, reader, http.StatusForbidden},
+		{"update someone else's quote", http.MethodPatch, "/v1/quotes/1", `{"content": "mine now"}`, other, http.StatusForbidden},
+		{"delete someone else's quote", http.MethodDelete, "/v1/quotes/1", "", other, http.StatusForbidden},
+		{"pin without quotes:admin", http.MethodPut, "/v1/daily-quotes/" + tomorrow, `{"quote_id": 1}`, owner, http.StatusForbidden},
+		{"owner updates", http.MethodPatch, "/v1/quotes/1", `{"content": "Know thyself!"}`, owner, http.StatusOK},
+		{"admin updates any quote", http.MethodPatch, "/v1/quotes/1", `{"author": "Thales"}`, admin, http.StatusOK},
+		{"owner deletes", http.MethodDelete, "/v1/quotes/1", "", owner, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, _ := sendRequest(t, handler, tt.method, tt.url, tt.body, tt.headers)
+			if w.Code != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+}
+
+func TestCORS(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.cors.trustedOrigins = []string{"https://qod.example", "https://*.partner.example"}
+	app.config.cors.allowCredentials = true
+	app.config.cors.maxAge = time.Minute
+	handler := app.routes()
+
+	tests := []struct {
+		name    string
+		origin  string
+		allowed bool
+	}{
+		{"exact origin", "https://qod.example", true},
+		{"subdomain", "https://app.partner.example", true},
+		{"nested subdomain", "https://eu.app.partner.example", true},
+		{"apex of a wildcard", "https://partner.example", false},
+		{"other scheme", "http://qod.example", false},
+		{"lookalike", "https://evilpartner.example", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, _ := sendRequest(t, handler, http.MethodOptions, "/v1/quotes/1", "", map[string]string{
+				"Origin":                         tt.origin,
+				"Access-Control-Request-Method":  http.MethodPatch,
+				"Access-Control-Request-Headers": "Content-Type, Authorization",
+			})
+
+			got := w.Header().Get("Access-Control-Allow-Origin")
+			if !tt.allowed {
+				if got != "" {
+					t.Errorf("expected no Access-Control-Allow-Origin, got %q", got)
+				}
+				return
+			}
+
+			if w.Code != http.StatusOK || got != tt.origin {
+				t.Fatalf("expected a 200 preflight for %s, got %d with origin %q", tt.origin, w.Code, got)
+			}
+			for header, want := range map[string]string{
+				"Access-Control-Allow-Methods":     corsAllowedMethods,
+				"Access-Control-Allow-Headers":     corsAllowedHeaders,
+				"Access-Control-Max-Age":           "60",
+				"Access-Control-Allow-Credentials": "true",
+			} {
+				if got := w.Header().Get(header); got != want {
+					t.Errorf("expected %s %q, got %q", header, want, got)
+				}
+			}
+		})
+	}
+
+	// the real request exposes the headers scripts need
+	w, _ := sendRequest(t, handler, http.MethodGet, "/v1/quotes", "", map[string]string{"Origin": "https://qod.example"})
+	if got := w.Header().Get("Access-Control-Expose-Headers"); got != corsExposedHeaders {
+		t.Errorf("expected Access-Control-Expose-Headers %q, got %q", corsExposedHeaders, got)
+	}
+}
+
+func TestCheckCORSOrigins(t *testing.T) {
+	tests := []struct {
+		name             string
+		patterns         []string
+		allowCredentials bool
+		wantErr          bool
+	}{
+		{"any origin", []string{"*"}, false, false},
+		{"listed origins with credentials", []string{"https://qod.example", "https://*.qod.example"}, true, false},
+		{"any origin with credentials", []string{"https://qod.example", "*"}, true, true},
+	}
+	for _, tt := range tests {
+		err := checkCORSOrigins(tt.patterns, tt.allowCredentials)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: expected an error %v, got %v", tt.name, tt.wantErr, err)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	app := newTestApplication(t)
+	handler := app.routes()
+
+	sendRequest(t, handler, http.MethodGet, "/v1/quotes", "", nil)
+	sendRequest(t, handler, http.MethodGet, "/v1/quotes/42", "", nil)
+	sendRequest(t, handler, http.MethodGet, "/v1/quotes/random", "", nil)
+	sendRequest(t, handler, http.MethodGet, "/v1/nowhere", "", nil)
+
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	app.metricsRoutes().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	body := w.Body.String()
+	for _, want := range []string{
+		"qod_http_requests_total 4",
+		`qod_http_responses_total{code="200"} 2`,
+		`qod_http_responses_total{code="404"} 2`,
+		"qod_http_requests_in_flight 0",
+		`qod_http_request_duration_seconds_count{method="GET",route="/v1/quotes/:id"} 1`,
+		`qod_http_request_duration_seconds_count{method="GET",route="/v1/quotes/random"} 1`,
+		`qod_http_request_duration_seconds_bucket{method="GET",route="unmatched",le="+Inf"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected the metrics to contain %q\n%s", want, body)
+		}
+	}
+
+	// the command line holds the database and SMTP passwords
+	r = httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+	w = httptest.NewRecorder()
+	app.metricsRoutes().ServeHTTP(w, r)
+	var vars map[string]any
+	err := json.Unmarshal(w.Body.Bytes(), &vars)
+	if err != nil {
+		t.Fatalf("expected JSON, got %q: %v", w.Body.String(), err)
+	}
+	if _, found := vars["cmdline"]; found {
+		t.Error("expected /debug/vars to leave out the command line")
+	}
+	if _, found := vars["memstats"]; !found {
+		t.Error("expected /debug/vars to show the memstats")
+	}
+
+	// the metrics are not on the public API
+	for _, path := range []string{"/metrics", "/debug/vars"} {
+		w, _ := sendRequest(t, handler, http.MethodGet, path, "", nil)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status %d for %s, got %d", http.StatusNotFound, path, w.Code)
+		}
+	}
+}
+
+func TestRequestIDAndAccessLog(t *testing.T) {
+	app := newTestApplication(t)
+	var logs bytes.Buffer
+	app.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	handler := app.routes()
+	auth := newTestUser(t, app, "ann@example.com", data.PermissionQuotesWrite)
+
+	// a sane ID is passed along and quoted in the error envelope
+	headers := map[string]string{"X-Request-ID": "lb-1234.abc", "Authorization": auth["Authorization"]}
+	w, body := sendRequest(t, handler, http.MethodGet, "/v1/quotes/42", "", headers)
+	if got := w.Header().Get("X-Request-ID"); got != "lb-1234.abc" {
+		t.Errorf("expected X-Request-ID %q, got %q", "lb-1234.abc", got)
+	}
+	if body["request_id"] != "lb-1234.abc" {
+		t.Errorf("expected the error envelope to carry the request ID, got %v", body["request_id"])
+	}
+
+	var line struct {
+		Msg       string `json:"msg"`
+		RequestID string `json:"request_id"`
+		Method    string `json:"method"`
+		Route     string `json:"route"`
+		Status    int    `json:"status"`
+		Bytes     int    `json:"bytes"`
+		ClientIP  string `json:"client_ip"`
+		UserID    int64  `json:"user_id"`
+	}
+	err := json.Unmarshal(logs.Bytes(), &line)
+	if err != nil {
+		t.Fatalf("expected one JSON access log line: %v\n%s", err, logs.String())
+	}
+	want := line
+	want.Msg, want.RequestID, want.Method, want.Route = "request", "lb-1234.abc", http.MethodGet, "/v1/quotes/:id"
+	want.Status, want.Bytes, want.ClientIP, want.UserID = http.StatusNotFound, w.Body.Len(), "192.0.2.1", 1
+	if line != want {
+		t.Errorf("expected the access log line %+v, got %+v", want, line)
+	}
+
+	// anything else is replaced
+	w, _ = sendRequest(t, handler, http.MethodGet, "/v1/quotes", "", map[string]string{"X-Request-ID": "bad id\n"})
+	if got := w.Header().Get("X-Request-ID"); got == "" || got == "bad id\n" {
+		t.Errorf("expected a new request ID, got %q", got)
+	}
+}
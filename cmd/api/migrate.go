@@ -0,0 +1,83 @@
+// Filename: cmd/api/migrate.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/Lee26Ed/qod/internal/migrate"
+	"github.com/Lee26Ed/qod/migrations"
+)
+
+// what -migrate asks for. An empty action means start the server
+type migrateCommand struct {
+	action  string
+	version uint
+}
+
+// parse -migrate=up|down|version|goto:N|force:N
+func parseMigrateCommand(val string) (migrateCommand, error) {
+	action, number, hasNumber := strings.Cut(val, ":")
+	switch action {
+	case "up", "down", "version":
+		if hasNumber {
+			return migrateCommand{}, fmt.Errorf("%s doesn't take a version", action)
+		}
+		return migrateCommand{action: action}, nil
+	case "goto", "force":
+		version, err := strconv.ParseUint(number, 10, 0)
+		if err != nil {
+			return migrateCommand{}, fmt.Errorf("%s needs a version, e.g. %s:7", action, action)
+		}
+		return migrateCommand{action: action, version: uint(version)}, nil
+	default:
+		return migrateCommand{}, errors.New("must be up, down, version, goto:N or force:N")
+	}
+}
+
+// run the migration command against -db-dsn. The migrations are
+// embedded so the container image can migrate its own database
+func runMigrateCommand(cfg configuration, logger *slog.Logger) error {
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+	migrator.Logger = logger
+
+	// no timeout, a migration rewriting a big table takes what it takes
+	ctx := context.Background()
+	command := cfg.migrate
+	switch command.action {
+	case "up":
+		err = migrator.Up(ctx)
+	case "down":
+		err = migrator.Down(ctx)
+	case "goto":
+		err = migrator.Goto(ctx, command.version)
+	case "force":
+		err = migrator.Force(ctx, command.version)
+	}
+	switch {
+	case errors.Is(err, migrate.ErrNoChange):
+		logger.Info("no migrations to run")
+	case err != nil:
+		return err
+	}
+
+	version, dirty, err := migrator.Version(ctx)
+	if err != nil {
+		return err
+	}
+	logger.Info("database schema", "version", version, "dirty", dirty, "latest", migrator.Latest())
+	return nil
+}
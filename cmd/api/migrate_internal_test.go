@@ -0,0 +1,34 @@
+// Filename: cmd/api/migrate_internal_test.go
+
+package main
+
+import "testing"
+
+func TestParseMigrateCommand(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    migrateCommand
+		wantErr bool
+	}{
+		{value: "up", want: migrateCommand{action: "up"}},
+		{value: "down", want: migrateCommand{action: "down"}},
+		{value: "version", want: migrateCommand{action: "version"}},
+		{value: "goto:7", want: migrateCommand{action: "goto", version: 7}},
+		{value: "force:0", want: migrateCommand{action: "force", version: 0}},
+		{value: "goto", wantErr: true},
+		{value: "goto:seven", wantErr: true},
+		{value: "up:3", wantErr: true},
+		{value: "sideways", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseMigrateCommand(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: expected error %t, got %v", tt.value, tt.wantErr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: expected %+v, got %+v", tt.value, tt.want, got)
+		}
+	}
+}
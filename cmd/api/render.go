@@ -0,0 +1,278 @@
+// Filename: cmd/api/render.go
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Lee26Ed/qod/internal/data"
+)
+
+// a format the quote responses can be sent in, picked with the
+// ?format= name or one of the media types in the Accept header
+type responseFormat struct {
+	name        string
+	contentType string
+	mediaTypes  []string
+}
+
+// in order of preference when the client likes several equally
+var responseFormats = []responseFormat{
+	{"json", "application/json", []string{"application/json"}},
+	{"text", "text/plain; charset=utf-8", []string{"text/plain"}},
+	{"csv", "text/csv; charset=utf-8", []string{"text/csv"}},
+	{"xml", "application/xml; charset=utf-8", []string{"application/xml", "text/xml"}},
+}
+
+// pick the format of the response. ?format= wins over the Accept
+// header, no header at all means JSON. ok is false when the client
+// accepts none of the responseFormats
+func negotiateFormat(r *http.Request) (responseFormat, bool) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		for _, format := range responseFormats {
+			if format.name == name {
+				return format, true
+			}
+		}
+		return responseFormat{}, false
+	}
+
+	accept := strings.TrimSpace(r.Header.Get("Accept"))
+	if accept == "" {
+		return responseFormats[0], true
+	}
+
+	ranges := parseAccept(accept)
+	best, bestQuality := responseFormat{}, 0.0
+	for _, format := range responseFormats {
+		quality := format.quality(ranges)
+		if quality > bestQuality {
+			best, bestQuality = format, quality
+		}
+	}
+	return best, bestQuality > 0
+}
+
+// one media range of an Accept header and its q value
+type acceptRange struct {
+	mediaType string
+	quality   float64
+}
+
+// Accept: text/plain, application/json;q=0.5, */*;q=0.1
+func parseAccept(header string) []acceptRange {
+	ranges := []acceptRange{}
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+		if mediaType == "" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.ToLower(key) != "q" {
+				continue
+			}
+			q, err := strconv.ParseFloat(value, 64)
+			if err != nil || q < 0 || q > 1 {
+				q = 0
+			}
+			quality = q
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, quality: quality})
+	}
+	return ranges
+}
+
+// how much the client wants the format. The most specific range that
+// matches decides, so "text/*;q=0.2, text/csv" gives CSV a q of 1
+func (f responseFormat) quality(ranges []acceptRange) float64 {
+	quality, specificity := 0.0, -1
+	for _, accepted := range ranges {
+		for _, mediaType := range f.mediaTypes {
+			kind, _, _ := strings.Cut(mediaType, "/")
+			s := -1
+			switch accepted.mediaType {
+			case mediaType:
+				s = 2
+			case kind + "/*":
+				s = 1
+			case "*/*":
+				s = 0
+			}
+			if s > specificity {
+				quality, specificity = accepted.quality, s
+			}
+		}
+	}
+	return quality
+}
+
+// send a quote response in the format the client negotiated. JSON gets
+// the whole envelope, the other formats the quote under "quote" or the
+// quotes under "quotes" (and XML the "@metadata" of the page too).
+// A 200 gets the handler's ETag (a weak one from the body if it set
+// none) and a 304 is sent instead when the client's copy is current
+func (a *application) writeResponse(w http.ResponseWriter, r *http.Request, status int, body envelope, headers http.Header) error {
+	w.Header().Add("Vary", "Accept")
+
+	format, ok := negotiateFormat(r)
+	if !ok {
+		a.notAcceptableResponse(w, r)
+		return nil
+	}
+
+	rendered, err := renderBody(format, body)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+	etag := w.Header().Get("ETag")
+	if etag != "" {
+		w.Header().Set("ETag", representationETag(etag, format))
+	}
+	if status == http.StatusOK {
+		if etag == "" {
+			etag = weakETag(rendered)
+		}
+		lastModified, _ := http.ParseTime(w.Header().Get("Last-Modified"))
+		if a.notModified(w, r, representationETag(etag, format), lastModified) {
+			return nil
+		}
+	}
+
+	w.Header().Set("Content-Type", format.contentType)
+	w.WriteHeader(status)
+	_, err = w.Write(rendered)
+	return err
+}
+
+// the body of a quote response in the format
+func renderBody(format responseFormat, body envelope) ([]byte, error) {
+	if format.name == "json" {
+		return marshalJSON(body)
+	}
+
+	var quotes []*data.Quotes
+	single := false
+	switch value := body["quote"].(type) {
+	case *data.Quotes:
+		quotes, single = []*data.Quotes{value}, true
+	default:
+		var ok bool
+		quotes, ok = body["quotes"].([]*data.Quotes)
+		if !ok {
+			return nil, fmt.Errorf("no quotes to render as %s", format.name)
+		}
+	}
+
+	var buf bytes.Buffer
+	var err error
+	switch format.name {
+	case "text":
+		for _, quote := range quotes {
+			fmt.Fprintf(&buf, "\"%s\" — %s\n", quote.Content, quote.Author)
+		}
+	case "csv":
+		err = renderCSV(&buf, quotes)
+	case "xml":
+		metadata, _ := body["@metadata"].(data.Metadata)
+		err = renderXML(&buf, quotes, single, metadata)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// the same columns as the CSV export
+func renderCSV(buf *bytes.Buffer, quotes []*data.Quotes) error {
+	writer, err := data.NewQuoteWriter(buf, "csv")
+	if err != nil {
+		return err
+	}
+	for _, quote := range quotes {
+		err := writer.Write(quote)
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// <quote id="1" version="1"><content>...</content><author id="2">...</author>...</quote>
+type xmlQuote struct {
+	XMLName   xml.Name  `xml:"quote"`
+	ID        int64     `xml:"id,attr"`
+	Version   int32     `xml:"version,attr"`
+	Content   string    `xml:"content"`
+	Author    xmlAuthor `xml:"author"`
+	Tags      []string  `xml:"tags>tag"`
+	CreatedBy int64     `xml:"created_by,omitempty"`
+	CreatedAt time.Time `xml:"created_at"`
+	UpdatedAt time.Time `xml:"updated_at"`
+}
+
+type xmlAuthor struct {
+	ID   int64  `xml:"id,attr"`
+	Name string `xml:",chardata"`
+}
+
+// a page of quotes, the attributes are the @metadata of the JSON
+type xmlQuoteList struct {
+	XMLName      xml.Name   `xml:"quotes"`
+	CurrentPage  int        `xml:"current_page,attr,omitempty"`
+	PageSize     int        `xml:"page_size,attr,omitempty"`
+	FirstPage    int        `xml:"first_page,attr,omitempty"`
+	LastPage     int        `xml:"last_page,attr,omitempty"`
+	TotalRecords int        `xml:"total_records,attr,omitempty"`
+	Quotes       []xmlQuote `xml:"quote"`
+}
+
+func renderXML(buf *bytes.Buffer, quotes []*data.Quotes, single bool, metadata data.Metadata) error {
+	converted := make([]xmlQuote, 0, len(quotes))
+	for _, quote := range quotes {
+		converted = append(converted, xmlQuote{
+			ID:        quote.ID,
+			Version:   quote.Version,
+			Content:   quote.Content,
+			Author:    xmlAuthor{ID: quote.AuthorID, Name: quote.Author},
+			Tags:      quote.Tags,
+			CreatedBy: quote.CreatedBy,
+			CreatedAt: quote.CreatedAt,
+			UpdatedAt: quote.UpdatedAt,
+		})
+	}
+
+	var document any = xmlQuoteList{
+		CurrentPage:  metadata.CurrentPage,
+		PageSize:     metadata.PageSize,
+		FirstPage:    metadata.FirstPage,
+		LastPage:     metadata.LastPage,
+		TotalRecords: metadata.TotalRecords,
+		Quotes:       converted,
+	}
+	if single {
+		document = converted[0]
+	}
+
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(buf)
+	enc.Indent("", "\t")
+	err := enc.Encode(document)
+	if err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	return nil
+}
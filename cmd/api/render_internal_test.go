@@ -0,0 +1,200 @@
+// Filename: cmd/api/render_internal_test.go
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		url    string
+		accept string
+		want   string
+	}{
+		{"/v1/quotes", "", "json"},
+		{"/v1/quotes", "*/*", "json"},
+		{"/v1/quotes", "text/plain", "text"},
+		{"/v1/quotes", "text/html, text/*;q=0.5", "text"},
+		{"/v1/quotes", "text/*;q=0.2, text/csv", "csv"},
+		{"/v1/quotes", "application/json;q=0.5, application/xml", "xml"},
+		{"/v1/quotes", "TEXT/XML", "xml"},
+		{"/v1/quotes", "application/json;q=0, */*", "text"},
+		{"/v1/quotes", "text/html", ""},
+		{"/v1/quotes", "application/json;q=0", ""},
+		{"/v1/quotes?format=csv", "application/json", "csv"},
+		{"/v1/quotes?format=yaml", "", ""},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		format, ok := negotiateFormat(r)
+		if ok != (tt.want != "") || format.name != tt.want {
+			t.Errorf("%s with Accept %q: expected %q, got %q (ok %t)", tt.url, tt.accept, tt.want, format.name, ok)
+		}
+	}
+}
+
+func TestQuoteFormats(t *testing.T) {
+	app := newTestApplication(t)
+	handler := app.routes()
+	auth := newTestUser(t, app, "writer@example.com", "quotes:write")
+
+	sendRequest(t, handler, http.MethodPost, "/v1/quotes",
+		`{"content": "Know thyself", "author": "Socrates", "tags": ["wisdom"]}`, auth)
+	sendRequest(t, handler, http.MethodPost, "/v1/quotes",
+		`{"content": "Carpe diem", "author": "Horace"}`, auth)
+
+	tests := []struct {
+		name        string
+		url         string
+		accept      string
+		status      int
+		contentType string
+		body        []string
+	}{
+		{
+			name:        "plain text quote",
+			url:         "/v1/quotes/1",
+			accept:      "text/plain",
+			status:      http.StatusOK,
+			contentType: "text/plain; charset=utf-8",
+			body:        []string{"\"Know thyself\" — Socrates\n"},
+		},
+		{
+			name:        "plain text list",
+			url:         "/v1/quotes?format=text",
+			status:      http.StatusOK,
+			contentType: "text/plain; charset=utf-8",
+			body:        []string{"\"Know thyself\" — Socrates\n\"Carpe diem\" — Horace\n"},
+		},
+		{
+			name:        "csv list",
+			url:         "/v1/quotes?sort=-id",
+			accept:      "text/csv",
+			status:      http.StatusOK,
+			contentType: "text/csv; charset=utf-8",
+			body: []string{
+				"id,content,author,author_id,tags,created_by,version\n" +
+					"2,Carpe diem,Horace,2,,1,1\n" +
+					"1,Know thyself,Socrates,1,wisdom,1,1\n",
+			},
+		},
+		{
+			name:        "xml list",
+			url:         "/v1/quotes?page_size=1",
+			accept:      "application/xml",
+			status:      http.StatusOK,
+			contentType: "application/xml; charset=utf-8",
+			body: []string{
+				`<?xml version="1.0" encoding="UTF-8"?>`,
+				`<quotes current_page="1" page_size="1" first_page="1" last_page="2" total_records="2">`,
+				`<quote id="1" version="1">`,
+				`<author id="1">Socrates</author>`,
+				`<tag>wisdom</tag>`,
+			},
+		},
+		{
+			name:        "xml quote",
+			url:         "/v1/quotes/2",
+			accept:      "text/xml",
+			status:      http.StatusOK,
+			contentType: "application/xml; charset=utf-8",
+			body:        []string{`<quote id="2" version="1">`, `<content>Carpe diem</content>`},
+		},
+		{
+			name:        "json by default",
+			url:         "/v1/quotes/1",
+			accept:      "*/*",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        []string{`"content": "Know thyself"`},
+		},
+		{
+			name:        "not acceptable",
+			url:         "/v1/quotes/1",
+			accept:      "text/html",
+			status:      http.StatusNotAcceptable,
+			contentType: "application/json",
+			body:        []string{"application/xml"},
+		},
+		{
+			name:        "unknown format",
+			url:         "/v1/quotes?format=yaml",
+			status:      http.StatusNotAcceptable,
+			contentType: "application/json",
+		},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.status, w.Code)
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%s: expected Content-Type %q, got %q", tt.name, tt.contentType, got)
+		}
+		if !strings.Contains(strings.Join(w.Header().Values("Vary"), ","), "Accept") {
+			t.Errorf("%s: expected Vary to include Accept", tt.name)
+		}
+		for _, want := range tt.body {
+			if !strings.Contains(w.Body.String(), want) {
+				t.Errorf("%s: expected the body to contain %q, got\n%s", tt.name, want, w.Body.String())
+			}
+		}
+	}
+
+	// the text is written out as is, not as a Go string literal
+	sendRequest(t, handler, http.MethodPost, "/v1/quotes",
+		`{"content": "Ἓν οἶδα, \"ὅτι\" οὐδὲν οἶδα", "author": "Socrates"}`, auth)
+	r := httptest.NewRequest(http.MethodGet, "/v1/quotes/3?format=text", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if got, want := w.Body.String(), "\"Ἓν οἶδα, \"ὅτι\" οὐδὲν οἶδα\" — Socrates\n"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestWriteNotAcceptable(t *testing.T) {
+	app := newTestApplication(t)
+	handler := app.routes()
+	auth := newTestUser(t, app, "writer@example.com", "quotes:write")
+	html := map[string]string{"Authorization": auth["Authorization"], "Accept": "text/html"}
+
+	// nothing is created for a response the client can't take
+	w, _ := sendRequest(t, handler, http.MethodPost, "/v1/quotes",
+		`{"content": "Know thyself", "author": "Socrates"}`, html)
+	if w.Code != http.StatusNotAcceptable {
+		t.Fatalf("expected status 406, got %d", w.Code)
+	}
+	w, _ = sendRequest(t, handler, http.MethodGet, "/v1/quotes/1", "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected no quote to be created, got status %d", w.Code)
+	}
+
+	// nor changed
+	sendRequest(t, handler, http.MethodPost, "/v1/quotes",
+		`{"content": "Know thyself", "author": "Socrates"}`, auth)
+	w, _ = sendRequest(t, handler, http.MethodPatch, "/v1/quotes/1", `{"content": "Carpe diem"}`, html)
+	if w.Code != http.StatusNotAcceptable {
+		t.Fatalf("expected status 406, got %d", w.Code)
+	}
+	_, body := sendRequest(t, handler, http.MethodGet, "/v1/quotes/1", "", nil)
+	quote := body["quote"].(map[string]any)
+	if quote["content"] != "Know thyself" || quote["version"] != float64(1) {
+		t.Errorf("expected the quote to be unchanged, got %v", quote)
+	}
+}
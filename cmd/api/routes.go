@@ -3,6 +3,7 @@ package main
 import (
 	"net/http"
 
+	"github.com/Lee26Ed/qod/internal/data"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -14,18 +15,81 @@ func (a *application) routes() http.Handler {
 	router.NotFound = http.HandlerFunc(a.notFoundResponse)
 	// handle 405
 	router.MethodNotAllowed = http.HandlerFunc(a.methodNotAllowedResponse)
-	// setup routes
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", a.healthCheckHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/quotes", a.createQuoteHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/quotes", a.listQuotesHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/quotes/:id", a.displayQuoteHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/quotes/:id", a.updateQuoteHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/quotes/:id", a.deleteQuoteHandler)
+	// every route records its pattern for the metrics
+	handle := func(method string, pattern string, handler http.HandlerFunc) {
+		router.HandlerFunc(method, pattern, a.routePattern(pattern, handler))
+	}
+
+	// setup routes. Reading is open to everyone (but the export),
+	// writing takes a logged in user with the right permission
+	handle(http.MethodGet, "/v1/healthcheck", a.healthCheckHandler)
+	handle(http.MethodGet, "/v1/healthcheck/live", a.livenessHandler)
+	handle(http.MethodGet, "/v1/healthcheck/ready", a.readinessHandler)
+	handle(http.MethodPost, "/v1/quotes", a.requirePermission(data.PermissionQuotesWrite, a.createQuoteHandler))
+	handle(http.MethodGet, "/v1/quotes", a.listQuotesHandler)
+	handle(http.MethodGet, "/v1/quotes/:id", a.quoteViewHandler)
+	handle(http.MethodPatch, "/v1/quotes/:id", a.requirePermission(data.PermissionQuotesWrite, a.updateQuoteHandler))
+	handle(http.MethodDelete, "/v1/quotes/:id", a.requirePermission(data.PermissionQuotesWrite, a.deleteQuoteHandler))
+	handle(http.MethodPut, "/v1/daily-quotes/:date", a.requirePermission(data.PermissionQuotesAdmin, a.pinDailyQuoteHandler))
+	handle(http.MethodGet, "/v1/feeds/quotes.rss", a.rssFeedHandler)
+	handle(http.MethodGet, "/v1/feeds/quotes.atom", a.atomFeedHandler)
+	handle(http.MethodGet, "/v1/tags", a.listTagsHandler)
+	handle(http.MethodPost, "/v1/authors", a.requireDatabase(a.requirePermission(data.PermissionQuotesWrite, a.createAuthorHandler)))
+	handle(http.MethodGet, "/v1/authors", a.requireDatabase(a.listAuthorsHandler))
+	handle(http.MethodGet, "/v1/authors/:id", a.requireDatabase(a.displayAuthorHandler))
+	handle(http.MethodPatch, "/v1/authors/:id", a.requireDatabase(a.requirePermission(data.PermissionQuotesWrite, a.updateAuthorHandler)))
+	handle(http.MethodDelete, "/v1/authors/:id", a.requireDatabase(a.requirePermission(data.PermissionQuotesWrite, a.deleteAuthorHandler)))
+	handle(http.MethodGet, "/v1/authors/:id/quotes", a.requireDatabase(a.listAuthorQuotesHandler))
+	handle(http.MethodPost, "/v1/users", a.registerUserHandler)
+	handle(http.MethodPut, "/v1/users/activated", a.activateUserHandler)
+	handle(http.MethodPut, "/v1/users/password", a.updateUserPasswordHandler)
+	handle(http.MethodPost, "/v1/tokens/authentication", a.createAuthenticationTokenHandler)
+	handle(http.MethodDelete, "/v1/tokens/authentication", a.requireAuthenticatedUser(a.deleteAuthenticationTokenHandler))
+	handle(http.MethodPost, "/v1/tokens/password-reset", a.createPasswordResetTokenHandler)
+	handle(http.MethodPost, "/v1/api-keys", a.requireActivatedUser(a.createAPIKeyHandler))
+	handle(http.MethodGet, "/v1/api-keys", a.requireActivatedUser(a.listAPIKeysHandler))
+	handle(http.MethodDelete, "/v1/api-keys/:id", a.requireActivatedUser(a.deleteAPIKeyHandler))
 
 	// wrap router with middleware
-    handler := a.recoverPanic(router) // your existing middleware
-    handler = a.enableCORS(handler)  
+    handler := a.authenticate(router)
+    handler = a.recoverPanic(handler) // your existing middleware
+	// the API key's limit needs to know who the key belongs to
+	handler = a.rateLimitAPIKey(handler)
+	handler = a.resolveAPIKey(handler)
+	// the IP address is limited before we look the key up
 	handler = a.rateLimit(handler)
+	// outside the limiter so the 429s carry the CORS headers too
+	handler = a.enableCORS(handler)
+	// everything after this sees the real client address
+	handler = a.collectMetrics(handler)
+	handler = a.logRequest(handler)
+	handler = a.realIP(handler)
+	handler = a.requestID(handler)
 
 	   return handler
 }
+
+// httprouter does not allow a static path such as /v1/quotes/today next
+// to the /v1/quotes/:id wildcard, so the named views are picked out here
+func (a *application) quoteViewHandler(w http.ResponseWriter, r *http.Request) {
+	switch httprouter.ParamsFromContext(r.Context()).ByName("id") {
+	case "today":
+		a.routePattern("/v1/quotes/today", a.quoteOfTheDayHandler)(w, r)
+	case "random":
+		a.routePattern("/v1/quotes/random", a.randomQuotesHandler)(w, r)
+	case "export":
+		a.routePattern("/v1/quotes/export", a.requirePermission(data.PermissionQuotesRead, a.exportQuotesHandler))(w, r)
+	default:
+		a.displayQuoteHandler(w, r)
+	}
+}
+
+// note the pattern of the matched route for the middleware
+func (a *application) routePattern(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if info := a.contextGetRequestInfo(r); info != nil {
+			info.route = pattern
+		}
+		next.ServeHTTP(w, r)
+	}
+}
@@ -22,21 +22,73 @@ func (app *application) Serve() error {
 		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
 	}
 	
+	// the metrics get a listener of their own (if any) so they
+	// never end up on the public port
+	var metricsSrv *http.Server
+	if app.config.metricsAddr != "" {
+		metricsSrv = &http.Server{
+			Addr:         app.config.metricsAddr,
+			Handler:      app.metricsRoutes(),
+			IdleTimeout:  time.Minute,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+			ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+		}
+	}
+
 	shutdownError := make(chan error)
 
+	// background jobs run until the server starts shutting down
+	background, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	go app.purgeExpiredTokens(background, app.config.tokens.cleanupInterval)
+	go app.pruneRateLimits(background, time.Minute)
+
 	go func() {
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 		s := <-quit
 		
 		app.logger.Info("Shutting down server", "signal", s.String())
+		stopBackground()
+
+		// fail the readiness check and keep serving for a while so
+		// the load balancer stops sending us requests first
+		app.shuttingDown.Store(true)
+		time.Sleep(app.config.shutdownDrainDelay)
 		
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 		
-		shutdownError <- srv.Shutdown(ctx)
+		if metricsSrv != nil {
+			err := metricsSrv.Shutdown(ctx)
+			if err != nil {
+				app.logger.Error("metrics listener shutdown", "error", err.Error())
+			}
+		}
+
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			shutdownError <- err
+			return
+		}
+
+		// let the emails that are still being sent go out
+		app.logger.Info("completing background tasks", "addr", srv.Addr)
+		app.wg.Wait()
+		shutdownError <- nil
 		}()
 		
+	if metricsSrv != nil {
+		go func() {
+			app.logger.Info("Starting metrics listener", "addr", metricsSrv.Addr)
+			err := metricsSrv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.Error("metrics listener", "error", err.Error())
+			}
+		}()
+	}
+
 	app.logger.Info("Starting Server", "addr", srv.Addr, "env", app.config.env)
 
 	err := srv.ListenAndServe()
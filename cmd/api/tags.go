@@ -0,0 +1,24 @@
+// Filename: cmd/api/tags.go
+package main
+
+import (
+	"net/http"
+)
+
+// GET /v1/tags
+// every tag in use and how many quotes have it
+func (a *application) listTagsHandler(w http.ResponseWriter, r *http.Request) {
+	tags, err := a.quoteModel.ListTags(r.Context())
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"tags": tags,
+	}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,183 @@
+// Filename: cmd/api/tokens.go
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Lee26Ed/qod/internal/data"
+	"github.com/Lee26Ed/qod/internal/validator"
+)
+
+// POST /v1/tokens/authentication
+// exchange an email and password for a bearer token
+func (a *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateEmail(v, incomingData.Email)
+	data.ValidatePasswordPlaintext(v, incomingData.Password)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// an unknown email and a wrong password get the same answer,
+	// after the same bcrypt work so the timing doesn't tell either
+	user, err := a.userModel.GetByEmail(r.Context(), incomingData.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			data.CompareDummyPassword(incomingData.Password)
+			a.invalidCredentialsResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	match, err := user.Password.Matches(incomingData.Password)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		a.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	token, err := a.tokenModel.New(r.Context(), user.ID, a.config.tokens.ttl, data.ScopeAuthentication)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"authentication_token": token,
+	}
+	err = a.writeJSON(w, http.StatusCreated, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// DELETE /v1/tokens/authentication
+// log out by revoking the token the request was made with
+func (a *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// requireAuthenticatedUser already checked the header
+	tokenPlaintext, _ := bearerToken(r)
+
+	err := a.tokenModel.Delete(r.Context(), data.ScopeAuthentication, tokenPlaintext)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"message": "you have been logged out",
+	}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// how long the emailed password reset token stays valid
+const passwordResetTokenTTL = 45 * time.Minute
+
+// POST /v1/tokens/password-reset
+// email a password reset token. The answer is the same whether or not
+// the email belongs to an account so nobody can find out who has one
+func (a *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		Email string `json:"email"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateEmail(v, incomingData.Email)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := a.userModel.GetByEmail(r.Context(), incomingData.Email)
+	switch {
+	case err == nil:
+		// the token is made in the background too so that
+		// known emails don't take noticeably longer to answer
+		a.background(func() {
+			a.sendPasswordResetEmail(user)
+		})
+	case errors.Is(err, data.ErrRecordNotFound):
+		// nothing to send, but we don't say so
+	default:
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"message": "if an account uses that email address you will receive password reset instructions",
+	}
+	err = a.writeJSON(w, http.StatusAccepted, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// make a password reset token for the user and email it to them
+func (a *application) sendPasswordResetEmail(user *data.User) {
+	// the request is over by now, its context is done
+	token, err := a.tokenModel.New(context.Background(), user.ID, passwordResetTokenTTL, data.ScopePasswordReset)
+	if err != nil {
+		a.logger.Error("creating a password reset token", "error", err.Error(), "user_id", user.ID)
+		return
+	}
+
+	emailData := map[string]any{
+		"name":               user.Name,
+		"passwordResetToken": token.Plaintext,
+	}
+	err = a.mailer.Send(user.Email, "token_password_reset.tmpl", emailData)
+	if err != nil {
+		a.logger.Error("sending the password reset email", "error", err.Error(), "user_id", user.ID)
+	}
+}
+
+// remove the expired tokens every interval until ctx is cancelled
+func (a *application) purgeExpiredTokens(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := a.tokenModel.DeleteExpired(ctx)
+			if err != nil {
+				a.logger.Error("purging expired tokens", "error", err.Error())
+				continue
+			}
+			if deleted > 0 {
+				a.logger.Info("purged expired tokens", "count", deleted)
+			}
+		}
+	}
+}
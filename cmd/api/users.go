@@ -0,0 +1,219 @@
+// Filename: cmd/api/users.go
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Lee26Ed/qod/internal/data"
+	"github.com/Lee26Ed/qod/internal/validator"
+)
+
+// how long the emailed activation token stays valid
+const activationTokenTTL = 3 * 24 * time.Hour
+
+// POST /v1/users
+// register a new account
+func (a *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := &data.User{
+		Name:      incomingData.Name,
+		Email:     incomingData.Email,
+		Activated: false,
+	}
+
+	// check everything before hashing the password: bcrypt is slow on
+	// purpose and refuses anything over 72 bytes
+	v := validator.New()
+	data.ValidateUser(v, user)
+	data.ValidatePasswordPlaintext(v, incomingData.Password)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = user.Password.Set(incomingData.Password)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the account, its permissions (new accounts may read quotes
+	// and add their own) and the activation token all or nothing
+	permissions := []string{data.PermissionQuotesRead, data.PermissionQuotesWrite}
+	token, err := a.userModel.Register(r.Context(), user, permissions, activationTokenTTL)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// talking to the mail server is slow, the client
+	// shouldn't have to wait for it
+	a.background(func() {
+		emailData := map[string]any{
+			"name":            user.Name,
+			"userID":          user.ID,
+			"activationToken": token.Plaintext,
+		}
+		err := a.mailer.Send(user.Email, "user_welcome.tmpl", emailData)
+		if err != nil {
+			a.logger.Error("sending the welcome email", "error", err.Error(), "user_id", user.ID)
+		}
+	})
+
+	// 202 since the account still has to be activated
+	data := envelope{
+		"user": user,
+	}
+	err = a.writeJSON(w, http.StatusAccepted, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// PUT /v1/users/activated
+// activate an account with the token we emailed
+func (a *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		Token string `json:"token"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateTokenPlaintext(v, incomingData.Token)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := a.userModel.GetForToken(r.Context(), data.ScopeActivation, incomingData.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired activation token")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user.Activated = true
+	err = a.userModel.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConflictResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// the activation tokens are single use
+	err = a.tokenModel.DeleteAllForUser(r.Context(), data.ScopeActivation, user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"user": user,
+	}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// PUT /v1/users/password
+// set a new password with the token we emailed. Every session of
+// the user is logged out since whoever had the old password may be in one
+func (a *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		Password string `json:"password"`
+		Token    string `json:"token"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidatePasswordPlaintext(v, incomingData.Password)
+	data.ValidateTokenPlaintext(v, incomingData.Token)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := a.userModel.GetForToken(r.Context(), data.ScopePasswordReset, incomingData.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = user.Password.Set(incomingData.Password)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.userModel.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConflictResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	for _, scope := range []string{data.ScopePasswordReset, data.ScopeAuthentication} {
+		err = a.tokenModel.DeleteAllForUser(r.Context(), scope, user.ID)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	data := envelope{
+		"message": "your password was successfully reset",
+	}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,174 @@
+// Filename: cmd/api/users_internal_test.go
+
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Lee26Ed/qod/internal/data"
+)
+
+// the token in the last email written by the file transport
+func readEmailedToken(t *testing.T, app *application) string {
+	t.Helper()
+
+	// wait for the email to be sent in the background
+	app.wg.Wait()
+
+	files, err := filepath.Glob(filepath.Join(app.config.mailer.dir, "*.eml"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("expected an email in %s", app.config.mailer.dir)
+	}
+	email, err := os.ReadFile(files[len(files)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	match := regexp.MustCompile(`"token": "([A-Z2-7]{26})"`).FindSubmatch(email)
+	if match == nil {
+		t.Fatalf("expected a token in the email\n%s", email)
+	}
+	return string(match[1])
+}
+
+func TestRegisterAndActivateUser(t *testing.T) {
+	app := newTestApplication(t)
+	handler := app.routes()
+
+	w, body := sendRequest(t, handler, http.MethodPost, "/v1/users",
+		`{"name": "Ann", "email": "ann@example.com", "password": "pa55word1234"}`, nil)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d", http.StatusAccepted, w.Code)
+	}
+	if got := body["user"].(map[string]any)["activated"]; got != false {
+		t.Errorf("expected a new account to be inactive, got activated %v", got)
+	}
+	activationToken := readEmailedToken(t, app)
+
+	_, body = sendRequest(t, handler, http.MethodPost, "/v1/tokens/authentication",
+		`{"email": "ann@example.com", "password": "pa55word1234"}`, nil)
+	bearer := body["authentication_token"].(map[string]any)["token"].(string)
+	auth := map[string]string{"Authorization": "Bearer " + bearer}
+
+	quote := `{"content": "Know thyself", "author": "Socrates"}`
+	w, _ = sendRequest(t, handler, http.MethodPost, "/v1/quotes", quote, auth)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status %d before activating, got %d", http.StatusForbidden, w.Code)
+	}
+
+	w, body = sendRequest(t, handler, http.MethodPut, "/v1/users/activated",
+		`{"token": "`+activationToken+`"}`, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if got := body["user"].(map[string]any)["activated"]; got != true {
+		t.Errorf("expected the account to be activated, got activated %v", got)
+	}
+
+	// the token only works once
+	w, _ = sendRequest(t, handler, http.MethodPut, "/v1/users/activated",
+		`{"token": "`+activationToken+`"}`, nil)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status %d, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+
+	w, _ = sendRequest(t, handler, http.MethodPost, "/v1/quotes", quote, auth)
+	if w.Code != http.StatusCreated {
+		t.Errorf("expected status %d after activating, got %d", http.StatusCreated, w.Code)
+	}
+}
+
+func TestRegisterUserValidation(t *testing.T) {
+	app := newTestApplication(t)
+	handler := app.routes()
+
+	tests := []struct {
+		name     string
+		password string
+	}{
+		{"empty", ""},
+		{"too short", "pa55"},
+		// bcrypt refuses these, they must not get as far as hashing
+		{"too long", strings.Repeat("a", 73)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, body := sendRequest(t, handler, http.MethodPost, "/v1/users",
+				`{"name": "Ann", "email": "ann@example.com", "password": "`+tt.password+`"}`, nil)
+			if w.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("expected status %d, got %d", http.StatusUnprocessableEntity, w.Code)
+			}
+			if _, found := body["error"].(map[string]any)["password"]; !found {
+				t.Errorf("expected a password error, got %v", body["error"])
+			}
+		})
+	}
+
+	// every problem is reported at once
+	w, body := sendRequest(t, handler, http.MethodPost, "/v1/users",
+		`{"name": "", "email": "not an email", "password": "pa55"}`, nil)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status %d, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+	for _, field := range []string{"name", "email", "password"} {
+		if _, found := body["error"].(map[string]any)[field]; !found {
+			t.Errorf("expected a %s error, got %v", field, body["error"])
+		}
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	app := newTestApplication(t)
+	handler := app.routes()
+	auth := newTestUser(t, app, "ann@example.com", data.PermissionQuotesWrite)
+
+	// known and unknown emails get the same answer
+	known, knownBody := sendRequest(t, handler, http.MethodPost, "/v1/tokens/password-reset",
+		`{"email": "ann@example.com"}`, nil)
+	unknown, unknownBody := sendRequest(t, handler, http.MethodPost, "/v1/tokens/password-reset",
+		`{"email": "nobody@example.com"}`, nil)
+	if known.Code != http.StatusAccepted || unknown.Code != known.Code {
+		t.Errorf("expected status %d for both, got %d and %d", http.StatusAccepted, known.Code, unknown.Code)
+	}
+	if knownBody["message"] != unknownBody["message"] {
+		t.Errorf("expected the same message, got %q and %q", knownBody["message"], unknownBody["message"])
+	}
+	resetToken := readEmailedToken(t, app)
+
+	w, _ := sendRequest(t, handler, http.MethodPut, "/v1/users/password",
+		`{"password": "short", "token": "`+resetToken+`"}`, nil)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status %d for a short password, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+
+	w, _ = sendRequest(t, handler, http.MethodPut, "/v1/users/password",
+		`{"password": "n3w-pa55word", "token": "`+resetToken+`"}`, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	// the old sessions are gone
+	w, _ = sendRequest(t, handler, http.MethodPost, "/v1/quotes",
+		`{"content": "Know thyself", "author": "Socrates"}`, auth)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d with the old token, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	w, _ = sendRequest(t, handler, http.MethodPost, "/v1/tokens/authentication",
+		`{"email": "ann@example.com", "password": "n3w-pa55word"}`, nil)
+	if w.Code != http.StatusCreated {
+		t.Errorf("expected status %d logging in with the new password, got %d", http.StatusCreated, w.Code)
+	}
+
+	// and the reset token only works once
+	w, _ = sendRequest(t, handler, http.MethodPut, "/v1/users/password",
+		`{"password": "an0ther-pa55word", "token": "`+resetToken+`"}`, nil)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status %d, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+}
@@ -0,0 +1,112 @@
+// Filename: cmd/qodadmin/export.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Lee26Ed/qod/internal/data"
+)
+
+// the same sort values as GET /v1/quotes
+var exportSortSafelist = []string{"id", "-id", "created_at", "-created_at", "author", "-author"}
+
+// qodadmin export [-format jsonl|csv] [-o FILE] [-author ...] [-content ...] [-tags ...]
+func runExport(args []string, stdout io.Writer, stderr io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: qodadmin export [flags]")
+		flags.PrintDefaults()
+	}
+	var db dbConfig
+	db.register(flags)
+	format := flags.String("format", "", "Output format (jsonl|csv), guessed from -o and jsonl otherwise")
+	output := flags.String("o", "-", "File to write, - writes to stdout")
+	author := flags.String("author", "", "Only the quotes of the matching authors")
+	content := flags.String("content", "", "Only the quotes whose content matches")
+	tags := flags.String("tags", "", "Only the quotes with any of the tags (comma separated)")
+	allTags := flags.Bool("all-tags", false, "The quotes must have all of the -tags")
+	sort := flags.String("sort", "id", "Sort order (id|created_at|author, - in front for descending)")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return errors.New("export doesn't take any arguments")
+	}
+	if *format == "" {
+		*format = importFormats[strings.ToLower(filepath.Ext(*output))]
+		if *format == "" || *format == "json" {
+			*format = "jsonl"
+		}
+	}
+	if !slices.Contains(data.ExportFormats, *format) {
+		return fmt.Errorf("unknown format %q (must be jsonl or csv)", *format)
+	}
+	if !slices.Contains(exportSortSafelist, *sort) {
+		return fmt.Errorf("invalid -sort value %q", *sort)
+	}
+
+	search := data.QuoteSearch{
+		Author:       *author,
+		Content:      *content,
+		MatchAllTags: *allTags,
+	}
+	if *tags != "" {
+		search.Tags = strings.Split(*tags, ",")
+	}
+
+	conn, err := db.open()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	out := stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	buffered := bufio.NewWriter(out)
+
+	writer, err := data.NewQuoteWriter(buffered, *format)
+	if err != nil {
+		return err
+	}
+
+	quotes := data.QuoteModel{DB: conn, Timeout: db.timeout}
+	filters := data.Filters{Sort: *sort, SortSafelist: exportSortSafelist}
+	count := 0
+	err = quotes.Export(context.Background(), search, filters, func(quote *data.Quotes) error {
+		count++
+		return writer.Write(quote)
+	})
+	if err != nil {
+		return err
+	}
+	err = writer.Flush()
+	if err != nil {
+		return err
+	}
+	err = buffered.Flush()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stderr, "exported %d quotes\n", count)
+	return nil
+}
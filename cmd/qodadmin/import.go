@@ -0,0 +1,296 @@
+// Filename: cmd/qodadmin/import.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Lee26Ed/qod/internal/data"
+	"github.com/Lee26Ed/qod/internal/validator"
+)
+
+// the formats import reads and the file extensions they are guessed from
+var importFormats = map[string]string{
+	".csv":    "csv",
+	".jsonl":  "jsonl",
+	".ndjson": "jsonl",
+	".json":   "json",
+}
+
+// qodadmin import [-format csv|jsonl|json] [-dry-run] FILE
+func runImport(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: qodadmin import [flags] FILE (- reads stdin)")
+		flags.PrintDefaults()
+	}
+	var db dbConfig
+	db.register(flags)
+	format := flags.String("format", "", "Input format (csv|jsonl|json), guessed from the file extension when empty")
+	dryRun := flags.Bool("dry-run", false, "Check the quotes and report what would be imported without writing anything")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("import needs exactly one file")
+	}
+
+	path := flags.Arg(0)
+	if *format == "" {
+		*format = importFormats[strings.ToLower(filepath.Ext(path))]
+		if *format == "" {
+			return fmt.Errorf("can't tell the format of %s, use -format", path)
+		}
+	}
+
+	input := stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	rows, invalid, err := readQuotes(input, *format)
+	if err != nil {
+		return err
+	}
+	// one bad row and nothing is imported, so the file can be fixed and
+	// imported again without ending up with half of it twice
+	for _, lineErr := range invalid {
+		fmt.Fprintln(stdout, lineErr)
+	}
+	if len(invalid) > 0 {
+		return fmt.Errorf("%d invalid rows, nothing was imported", len(invalid))
+	}
+	if len(rows) == 0 {
+		return errors.New("no quotes to import")
+	}
+
+	conn, err := db.open()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	quotes := data.QuoteModel{DB: conn, Timeout: db.timeout}
+	result, err := quotes.Import(context.Background(), rows, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range result.Duplicates {
+		fmt.Fprintf(stdout, "line %d: duplicate, skipped\n", line)
+	}
+	fmt.Fprintf(stdout, "imported %d quotes, skipped %d duplicates\n", result.Inserted, len(result.Duplicates))
+	if *dryRun {
+		fmt.Fprintln(stdout, "dry run, nothing was written")
+	}
+	return nil
+}
+
+// the problems with one row of the input
+type lineError struct {
+	line   int
+	errors map[string]string
+}
+
+func (e lineError) String() string {
+	messages := []string{}
+	for _, key := range slices.Sorted(maps.Keys(e.errors)) {
+		messages = append(messages, key+" "+e.errors[key])
+	}
+	return fmt.Sprintf("line %d: %s", e.line, strings.Join(messages, "; "))
+}
+
+// a quote as it appears in the input. The other fields of an exported
+// quote (id, version...) are ignored
+type importRecord struct {
+	Content string   `json:"content"`
+	Author  string   `json:"author"`
+	Tags    []string `json:"tags"`
+}
+
+// collects the valid rows and the errors of the others
+type importReader struct {
+	rows    []data.ImportRow
+	invalid []lineError
+}
+
+// check the record like createQuoteHandler checks a new quote
+func (ir *importReader) add(line int, record importRecord) {
+	quote := &data.Quotes{
+		Content: record.Content,
+		Author:  record.Author,
+		Tags:    data.NormalizeTags(record.Tags),
+	}
+
+	v := validator.New()
+	data.ValidateQuote(v, quote)
+	if !v.IsEmpty() {
+		ir.invalid = append(ir.invalid, lineError{line: line, errors: v.Errors})
+		return
+	}
+	ir.rows = append(ir.rows, data.ImportRow{Line: line, Quote: quote})
+}
+
+func (ir *importReader) fail(line int, key string, err error) {
+	ir.invalid = append(ir.invalid, lineError{line: line, errors: map[string]string{key: err.Error()}})
+}
+
+// read the quotes in the format. Rows that can't be used end up in the
+// line errors, an error means the input could not be read at all
+func readQuotes(r io.Reader, format string) ([]data.ImportRow, []lineError, error) {
+	var ir importReader
+	var err error
+	switch format {
+	case "csv":
+		err = ir.readCSV(r)
+	case "jsonl":
+		err = ir.readJSONLines(r)
+	case "json":
+		err = ir.readJSONArray(r)
+	default:
+		err = fmt.Errorf("unknown format %q (must be csv, jsonl or json)", format)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return ir.rows, ir.invalid, nil
+}
+
+// a header row names the columns: content and author, tags is
+// optional and holds the tags separated by commas
+func (ir *importReader) readCSV(r io.Reader) error {
+	reader := csv.NewReader(r)
+
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("reading the CSV header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		// spreadsheets like to start the file with a byte order mark
+		name = strings.TrimPrefix(name, "\ufeff")
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"content", "author"} {
+		if _, ok := columns[required]; !ok {
+			return fmt.Errorf("the CSV header has no %s column", required)
+		}
+	}
+	tagsColumn, hasTags := columns["tags"]
+
+	for {
+		fields, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		// a row with the wrong number of fields is that row's problem,
+		// anything else means we lost track of where the rows are
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) && errors.Is(err, csv.ErrFieldCount) {
+			ir.fail(parseErr.StartLine, "row", parseErr.Err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := reader.FieldPos(0)
+
+		record := importRecord{
+			Content: fields[columns["content"]],
+			Author:  fields[columns["author"]],
+		}
+		if hasTags && strings.TrimSpace(fields[tagsColumn]) != "" {
+			record.Tags = strings.Split(fields[tagsColumn], ",")
+		}
+		ir.add(line, record)
+	}
+}
+
+// one quote per line, blank lines are skipped
+func (ir *importReader) readJSONLines(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		var record importRecord
+		err := json.Unmarshal(text, &record)
+		if err != nil {
+			ir.fail(line, "json", err)
+			continue
+		}
+		ir.add(line, record)
+	}
+	return scanner.Err()
+}
+
+// a single array of quotes. The errors point at the line
+// each quote starts on
+func (ir *importReader) readJSONArray(r io.Reader) error {
+	input, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(input))
+	token, err := dec.Token()
+	if err != nil || token != json.Delim('[') {
+		return errors.New("the JSON input must be an array of quotes")
+	}
+
+	for dec.More() {
+		line := lineAt(input, dec.InputOffset())
+
+		var record importRecord
+		err := dec.Decode(&record)
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		// the decoder skipped the value, carry on with the next one
+		case errors.As(err, &typeErr):
+			ir.fail(line, "json", err)
+		case err != nil:
+			return fmt.Errorf("line %d: %w", line, err)
+		default:
+			ir.add(line, record)
+		}
+	}
+
+	_, err = dec.Token()
+	return err
+}
+
+// the line of the next value after offset, skipping the
+// whitespace and the comma before it
+func lineAt(input []byte, offset int64) int {
+	i := int(offset)
+	for i < len(input) && strings.ContainsRune(" \t\r\n,", rune(input[i])) {
+		i++
+	}
+	return 1 + bytes.Count(input[:i], []byte("\n"))
+}
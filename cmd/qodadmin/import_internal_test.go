@@ -0,0 +1,118 @@
+// Filename: cmd/qodadmin/import_internal_test.go
+
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadQuotes(t *testing.T) {
+	tests := []struct {
+		name        string
+		format      string
+		input       string
+		wantLines   []int
+		wantInvalid []string
+	}{
+		{
+			name:   "csv",
+			format: "csv",
+			input: "\ufeffAuthor,content,tags\n" +
+				"Seneca,\"Luck is what happens when preparation meets opportunity.\",\"Luck, life\"\n" +
+				"Anonymous,,\n" +
+				"\"Multi\nLine\",Second line quote,\n" +
+				"too,many,fields,here\n",
+			wantLines: []int{2, 4},
+			wantInvalid: []string{
+				"line 3: content must be provided",
+				"line 6: row wrong number of fields",
+			},
+		},
+		{
+			name:   "json lines",
+			format: "jsonl",
+			input: `{"content": "Know thyself.", "author": "Socrates", "id": 12}` + "\n" +
+				"\n" +
+				`{"content": "Know thyself."` + "\n" +
+				`{"content": "No author"}` + "\n" +
+				`{"content": "Tagged", "author": "A", "tags": ["x", "X", " "]}` + "\n",
+			wantLines: []int{1},
+			wantInvalid: []string{
+				"line 3: json unexpected end of JSON input",
+				"line 4: author must be provided",
+				"line 5: tags must not contain empty tags",
+			},
+		},
+		{
+			name:   "json array",
+			format: "json",
+			input: "[\n" +
+				`  {"content": "One", "author": "A"},` + "\n" +
+				`  {"content": 2, "author": "B"},` + "\n" +
+				"  {\n" +
+				`    "content": "Three", "author": "C", "tags": ["t"]` + "\n" +
+				"  }\n" +
+				"]\n",
+			wantLines: []int{2, 4},
+			wantInvalid: []string{
+				"line 3: json json: cannot unmarshal number into Go struct field importRecord.content of type string",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		rows, invalid, err := readQuotes(strings.NewReader(tt.input), tt.format)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		lines := []int{}
+		for _, row := range rows {
+			lines = append(lines, row.Line)
+		}
+		if !slices.Equal(lines, tt.wantLines) {
+			t.Errorf("%s: expected the quotes of lines %v, got %v", tt.name, tt.wantLines, lines)
+		}
+
+		messages := []string{}
+		for _, lineErr := range invalid {
+			messages = append(messages, lineErr.String())
+		}
+		if !slices.Equal(messages, tt.wantInvalid) {
+			t.Errorf("%s: expected the errors\n%q\ngot\n%q", tt.name, tt.wantInvalid, messages)
+		}
+	}
+
+	// the tags are normalized like the API does
+	rows, _, err := readQuotes(strings.NewReader("content,author,tags\nHi,A,\" Life ,love,life\"\n"), "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rows[0].Quote.Tags; !slices.Equal(got, []string{"life", "love"}) {
+		t.Errorf("expected the tags [life love], got %q", got)
+	}
+}
+
+func TestReadQuotesMalformed(t *testing.T) {
+	tests := []struct {
+		format string
+		input  string
+		want   string
+	}{
+		{format: "csv", input: "content,tags\nHi,x\n", want: "no author column"},
+		{format: "csv", input: "", want: "CSV header"},
+		{format: "json", input: `{"content": "Hi"}`, want: "must be an array"},
+		{format: "json", input: "[\n{\"content\": \"Hi\",}\n]", want: "line 2"},
+		{format: "xml", input: "<quotes/>", want: "unknown format"},
+	}
+
+	for _, tt := range tests {
+		_, _, err := readQuotes(strings.NewReader(tt.input), tt.format)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s %q: expected an error containing %q, got %v", tt.format, tt.input, tt.want, err)
+		}
+	}
+}
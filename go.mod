@@ -5,6 +5,6 @@ go 1.25.0
 require (
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/lib/pq v1.10.9
-    golang.org/x/time v0.13.0
+	golang.org/x/crypto v0.42.0
+	golang.org/x/time v0.13.0
 )
-
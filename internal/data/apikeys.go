@@ -0,0 +1,184 @@
+// Filename: internal/data/apikeys.go
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Lee26Ed/qod/internal/validator"
+)
+
+// RateTier is how fast the holder of an API key may call us.
+// The requests refill at RPS per second up to Burst at once
+type RateTier struct {
+	RPS   float64
+	Burst int
+}
+
+// the tier new keys get
+const DefaultAPIKeyTier = "basic"
+
+// how many keys a user may hold at once. The keys of a user on the same
+// tier share one rate limit, the cap keeps the lookups they cost us in
+// check too
+const MaxAPIKeysPerUser = 10
+
+// the tiers an API key can be on
+var APIKeyTiers = map[string]RateTier{
+	"basic":    {RPS: 10, Burst: 20},
+	"standard": {RPS: 50, Burst: 100},
+	"premium":  {RPS: 200, Burst: 400},
+}
+
+// every key starts with the prefix so it is easy to spot in a config file
+const apiKeyPrefix = "qod_"
+
+// APIKey lets a machine client call the API as its owner. Like the
+// tokens we only keep the hash, the plaintext is shown once on creation
+type APIKey struct {
+	ID        int64     `json:"id"`
+	Plaintext string    `json:"key,omitempty"`
+	Hash      []byte    `json:"-"`
+	UserID    int64     `json:"-"`
+	Name      string    `json:"name"`
+	Tier      string    `json:"tier"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NewAPIKey makes a key for the user, it still has to be inserted
+func NewAPIKey(userID int64, name string, tier string) *APIKey {
+	key := &APIKey{
+		Plaintext: apiKeyPrefix + rand.Text(),
+		UserID:    userID,
+		Name:      name,
+		Tier:      tier,
+	}
+	key.Hash = hashToken(key.Plaintext)
+	return key
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	_, found := APIKeyTiers[key.Tier]
+	v.Check(found, "tier", "must be one of basic, standard or premium")
+}
+
+func ValidateAPIKeyPlaintext(v *validator.Validator, plaintext string) {
+	v.Check(strings.HasPrefix(plaintext, apiKeyPrefix), "key", "must start with "+apiKeyPrefix)
+	v.Check(len(plaintext) == len(apiKeyPrefix)+26, "key", "must be 30 bytes long")
+}
+
+// An APIKeyModel expects a connection pool
+type APIKeyModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Insert the hash of a new key
+func (m APIKeyModel) Insert(ctx context.Context, key *APIKey) error {
+	query := `
+        INSERT INTO api_keys (hash, user_id, name, tier)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id, created_at
+      `
+	args := []any{key.Hash, key.UserID, key.Name, key.Tier}
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+}
+
+// GetByPlaintext finds the key a client sent us
+func (m APIKeyModel) GetByPlaintext(ctx context.Context, plaintext string) (*APIKey, error) {
+	query := `
+        SELECT id, hash, user_id, name, tier, created_at
+        FROM api_keys
+        WHERE hash = $1
+      `
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var key APIKey
+	err := m.DB.QueryRowContext(ctx, query, hashToken(plaintext)).Scan(
+		&key.ID,
+		&key.Hash,
+		&key.UserID,
+		&key.Name,
+		&key.Tier,
+		&key.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &key, nil
+}
+
+// GetAllForUser lists the keys of a user, oldest first
+func (m APIKeyModel) GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error) {
+	query := `
+        SELECT id, hash, user_id, name, tier, created_at
+        FROM api_keys
+        WHERE user_id = $1
+        ORDER BY id
+      `
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		var key APIKey
+		err := rows.Scan(&key.ID, &key.Hash, &key.UserID, &key.Name, &key.Tier, &key.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Delete revokes a key. Users can only revoke their own keys,
+// the key of someone else is reported as not found
+func (m APIKeyModel) Delete(ctx context.Context, id int64, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM api_keys WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+// Filename: internal/data/authors.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Lee26Ed/qod/internal/validator"
+	"github.com/lib/pq"
+)
+
+// Author is the person the quotes are attributed to.
+// Born and Died are dates (YYYY-MM-DD) and may be left empty
+type Author struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Bio       string    `json:"bio"`
+	Born      string    `json:"born,omitempty"`
+	Died      string    `json:"died,omitempty"`
+	CreatedAt time.Time `json:"-"`
+	Version   int32     `json:"version"`
+}
+
+// An AuthorModel expects a connection pool
+type AuthorModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Check the fields of an author
+func ValidateAuthor(v *validator.Validator, author *Author) {
+	v.Check(author.Name != "", "name", "must be provided")
+	v.Check(len(author.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(author.Bio) <= 2000, "bio", "must not be more than 2000 bytes long")
+
+	born, bornErr := time.Parse(DayLayout, author.Born)
+	v.Check(author.Born == "" || bornErr == nil, "born", "must be a date in the format YYYY-MM-DD")
+	died, diedErr := time.Parse(DayLayout, author.Died)
+	v.Check(author.Died == "" || diedErr == nil, "died", "must be a date in the format YYYY-MM-DD")
+
+	if bornErr == nil && diedErr == nil {
+		v.Check(!died.Before(born), "died", "must not be before born")
+	}
+}
+
+// the columns of an author, the dates come back as YYYY-MM-DD text
+const authorColumns = `id, created_at, name, bio,
+               COALESCE(born::text, ''), COALESCE(died::text, ''), version`
+
+func (author *Author) scanTargets() []any {
+	return []any{
+		&author.ID,
+		&author.CreatedAt,
+		&author.Name,
+		&author.Bio,
+		&author.Born,
+		&author.Died,
+		&author.Version,
+	}
+}
+
+// turn the errors of unique and foreign key constraints into ours
+func authorError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		// unique_violation on the name
+		case "23505":
+			return ErrDuplicateAuthor
+		// foreign_key_violation, quotes still point at the author
+		case "23503":
+			return ErrAuthorHasQuotes
+		}
+	}
+	return err
+}
+
+// Insert a new author
+func (m AuthorModel) Insert(ctx context.Context, author *Author) error {
+	query := `
+        INSERT INTO authors (name, bio, born, died)
+        VALUES ($1, $2, NULLIF($3, '')::date, NULLIF($4, '')::date)
+        RETURNING id, created_at, version
+      `
+	args := []any{author.Name, author.Bio, author.Born, author.Died}
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&author.ID,
+		&author.CreatedAt,
+		&author.Version,
+	)
+	return authorError(err)
+}
+
+// Get a specific author
+func (m AuthorModel) Get(ctx context.Context, id int64) (*Author, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+        SELECT ` + authorColumns + `
+        FROM authors
+        WHERE id = $1
+      `
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var author Author
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(author.scanTargets()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &author, nil
+}
+
+// Update an author if it still has the version the client read
+func (m AuthorModel) Update(ctx context.Context, author *Author) error {
+	query := `
+        UPDATE authors
+        SET name = $1, bio = $2, born = NULLIF($3, '')::date,
+            died = NULLIF($4, '')::date, version = version + 1
+        WHERE id = $5 AND version = $6
+        RETURNING version
+      `
+	args := []any{author.Name, author.Bio, author.Born, author.Died, author.ID, author.Version}
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&author.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return authorError(err)
+		}
+	}
+	return nil
+}
+
+// Delete an author. Authors that still have quotes can't be deleted
+func (m AuthorModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM authors WHERE id = $1`, id)
+	if err != nil {
+		return authorError(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Get all the authors whose name matches, one page at a time
+func (m AuthorModel) GetAll(ctx context.Context, name string, filters Filters) ([]*Author, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT COUNT(*) OVER(), %s
+        FROM authors
+        WHERE (to_tsvector('simple', name) @@
+              plainto_tsquery('simple', $1) OR $1 = '')
+        ORDER BY %s %s, id ASC
+        LIMIT $2 OFFSET $3
+      `, authorColumns, filters.SortColumn(), filters.SortDirection())
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, name, filters.Limit(), filters.Offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	authors := []*Author{}
+	for rows.Next() {
+		var author Author
+		err := rows.Scan(append([]any{&totalRecords}, author.scanTargets()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		authors = append(authors, &author)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return authors, metadata, nil
+}
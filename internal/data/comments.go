@@ -6,90 +6,220 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/Lee26Ed/qod/internal/validator"
+	"github.com/lib/pq"
 )
 
 // each name begins with uppercase so that they are exportable/public
 type Quotes struct {
     ID int64                     `json:"id"`                   
     Content  string              `json:"content"`     
+    AuthorID int64               `json:"author_id"`
     Author  string               `json:"author"`
-    CreatedAt  time.Time         `json:"-"`     
+    Tags  []string               `json:"tags"`
+    CreatedBy int64              `json:"created_by"`
+    CreatedAt  time.Time         `json:"created_at"`
+    UpdatedAt  time.Time         `json:"updated_at"`
     Version int32                `json:"version"`      
 } 
 
+// the columns of a quote for the SELECTs on quoteTables. The author's
+// name and the tags come from their own tables. Scan them into
+// quote.scanTargets(). The quotes from before we had users have
+// no creator, they come back with created_by 0
+const quoteColumns = `quotes.id, quotes.created_at, quotes.updated_at, quotes.content,
+               quotes.author_id, authors.name AS author,
+               COALESCE(quotes.created_by, 0), quotes.version,` + quoteTagsColumn
+
+const quoteTables = `quotes INNER JOIN authors ON authors.id = quotes.author_id`
+
+// where rows.Scan() should put each of the quoteColumns
+func (quote *Quotes) scanTargets() []any {
+	return []any{
+		&quote.ID,
+		&quote.CreatedAt,
+		&quote.UpdatedAt,
+		&quote.Content,
+		&quote.AuthorID,
+		&quote.Author,
+		&quote.CreatedBy,
+		&quote.Version,
+		pq.Array(&quote.Tags),
+	}
+}
+
+// QuoteSearch holds the filters shared by every endpoint that searches
+// the quotes (the list, random quotes...). Empty fields match everything.
+// A quote matches the Tags if it has any of them, or all of
+// them when MatchAllTags is set. AuthorID limits the search to one author
+type QuoteSearch struct {
+	Content      string
+	Author       string
+	AuthorID     int64
+	Tags         []string
+	MatchAllTags bool
+}
+
+// the WHERE clause for a search. The search terms take the first
+// placeholders so the caller's own arguments start at len(args)+1
+func (s QuoteSearch) where() (string, []any) {
+	clause := `
+        WHERE (to_tsvector('simple', quotes.content) @@
+              plainto_tsquery('simple', $1) OR $1 = '') 
+        AND (to_tsvector('simple', authors.name) @@ 
+             plainto_tsquery('simple', $2) OR $2 = '') 
+        AND (cardinality($3::text[]) = 0 OR (
+             SELECT COUNT(*) FROM quotes_tags qt
+             INNER JOIN tags t ON t.id = qt.tag_id
+             WHERE qt.quote_id = quotes.id AND t.name = ANY($3)
+             ) >= CASE WHEN $4 THEN cardinality($3::text[]) ELSE 1 END)
+        AND (quotes.author_id = $5 OR $5::bigint = 0)
+      `
+	return clause, []any{s.Content, s.Author, pq.Array(NormalizeTags(s.Tags)), s.MatchAllTags, s.AuthorID}
+}
+
 // A QuoteModel expects a connection pool
+// Timeout is how long a single query may run before we give up on it.
+// If it is not set we fall back to DefaultQueryTimeout
 type QuoteModel struct {
     DB *sql.DB
+    Timeout time.Duration
+}
+
+// No database operation should take more than 3 seconds unless
+// configured otherwise
+const DefaultQueryTimeout = 3 * time.Second
+
+// derive the context for a single query from the caller's context so
+// that a client going away (or the server shutting down) cancels the query
+func (q QuoteModel) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withQueryTimeout(ctx, q.Timeout)
+}
+
+// shared by the models, a zero timeout means DefaultQueryTimeout
+func withQueryTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = DefaultQueryTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// find the author of a quote inside the transaction writing the quote.
+// With an AuthorID the author must exist, otherwise we look the name up
+// and add the author the first time we see them
+func resolveAuthor(ctx context.Context, tx *sql.Tx, quote *Quotes) error {
+	if quote.AuthorID > 0 {
+		err := tx.QueryRowContext(ctx, `SELECT name FROM authors WHERE id = $1`,
+			quote.AuthorID).Scan(&quote.Author)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrAuthorNotFound
+		}
+		return err
+	}
+
+	// DO UPDATE (rather than DO NOTHING) so that RETURNING
+	// gives us the id of an existing author as well
+	query := `
+        INSERT INTO authors (name)
+        VALUES ($1)
+        ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
+        RETURNING id
+      `
+	quote.Author = strings.TrimSpace(quote.Author)
+	return tx.QueryRowContext(ctx, query, quote.Author).Scan(&quote.AuthorID)
 }
 
 // Create a function that performs the validation checks
 func ValidateQuote(v *validator.Validator, quote *Quotes) {
+	// the author is stored trimmed, check what will be stored
+	quote.Author = strings.TrimSpace(quote.Author)
 	// check if the Content field is empty
     v.Check(quote.Content != "", "content", "must be provided")
-	// check if the Author field is empty
-    v.Check(quote.Author != "", "author", "must be provided")
+	// check if the Author field is empty (an existing author
+	// can be given by id instead)
+    v.Check(quote.Author != "" || quote.AuthorID > 0, "author", "must be provided")
 	// check if the Content field is empty
     v.Check(len(quote.Content) <= 100, "content", "must not be more than 100 bytes long")
-	// check if the Author field is empty
+	// check if the Author field is too long (an existing author with
+	// a longer name can still be given by id)
      v.Check(len(quote.Author) <= 25, "author", "must not be more than 25 bytes long")
+	// check the tags
+	ValidateTags(v, quote.Tags)
 }
 
 // Insert a new row in the quotes table
 // Expects a pointer to the actual quote
-func (q QuoteModel) Insert(quote *Quotes) error {
+func (q QuoteModel) Insert(ctx context.Context, quote *Quotes) error {
    // the SQL query to be executed against the database table
     query := `
-        INSERT INTO quotes (content, author)
-        VALUES ($1, $2)
-        RETURNING id, created_at, version
+        INSERT INTO quotes (content, author_id, created_by)
+        VALUES ($1, $2, NULLIF($3::bigint, 0))
+        RETURNING id, created_at, updated_at, version
         `
-  // the actual values to replace $1, and $2
-   args := []any{quote.Content, quote.Author}
  
-	// Create a context with a 3-second timeout. No database
-	// operation should take more than 3 seconds or we will quit it
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	// Limit how long the query may take. It is also cancelled
+	// as soon as the caller's context is
+	ctx, cancel := q.queryContext(ctx)
 	defer cancel()
+
+	// the quote, its author and its tags are written together or not at all
+	tx, err := q.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = resolveAuthor(ctx, tx, quote)
+	if err != nil {
+		return err
+	}
+  // the actual values to replace $1, $2 and $3
+   args := []any{quote.Content, quote.AuthorID, quote.CreatedBy}
+
 	// execute the query against the quotes database table. We ask for the the
 	// id, created_at, and version to be sent back to us which we will use
 	// to update the Quote struct later on
-	return q.DB.QueryRowContext(ctx, query, args...).Scan(
+	err = tx.QueryRowContext(ctx, query, args...).Scan(
 														&quote.ID,
 														&quote.CreatedAt,
+														&quote.UpdatedAt,
 														&quote.Version)
+	if err != nil {
+		return err
+	}
 
+	err = setQuoteTags(ctx, tx, quote.ID, quote.Tags)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 
 // Get a specific Quote from the quotes table
-func (q QuoteModel) Get(id int64) (*Quotes, error) {
+func (q QuoteModel) Get(ctx context.Context, id int64) (*Quotes, error) {
    // check if the id is valid
     if id < 1 {
         return nil, ErrRecordNotFound
     }
    // the SQL query to be executed against the database table
     query := `
-        SELECT id, created_at, content, author, version
-        FROM quotes
-        WHERE id = $1
+        SELECT ` + quoteColumns + `
+        FROM ` + quoteTables + `
+        WHERE quotes.id = $1
       `
 	// declare a variable of type Quote to store the returned quote
 	var quote Quotes
 
-	// Set a 3-second context/timer
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	// Set the query timer on top of the caller's context
+	ctx, cancel := q.queryContext(ctx)
 	defer cancel()
 
-	err := q.DB.QueryRowContext(ctx, query, id).Scan (
-												&quote.ID,
-												&quote.CreatedAt,
-												&quote.Content,
-												&quote.Author,
-												&quote.Version,
-												)
+	err := q.DB.QueryRowContext(ctx, query, id).Scan(quote.scanTargets()...)
 	// check for which type of error
 	if err != nil {
 		switch {
@@ -103,25 +233,53 @@ func (q QuoteModel) Get(id int64) (*Quotes, error) {
 	}
 
 // Update a specific Quote from the quotes table
-func (q QuoteModel) Update(quote *Quotes) error {
+func (q QuoteModel) Update(ctx context.Context, quote *Quotes) error {
 	// The SQL query to be executed against the database table
 	// Every time we make an update, we increment the version number
+	// The row is only updated if it still has the version the client read,
+	// otherwise someone else changed it in the meantime
 	query := `
         UPDATE quotes
-        SET content = $1, author = $2, version = version + 1
-        WHERE id = $3
-        RETURNING version
+        SET content = $1, author_id = $2, version = version + 1, updated_at = NOW()
+        WHERE id = $3 AND version = $4
+        RETURNING version, updated_at
       `
-   args := []any{quote.Content, quote.Author, quote.ID}
-   ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+   ctx, cancel := q.queryContext(ctx)
    defer cancel()
 
-   return q.DB.QueryRowContext(ctx, query, args...).Scan(&quote.Version)
+   tx, err := q.DB.BeginTx(ctx, nil)
+   if err != nil {
+       return err
+   }
+   defer tx.Rollback()
+
+   err = resolveAuthor(ctx, tx, quote)
+   if err != nil {
+       return err
+   }
+   args := []any{quote.Content, quote.AuthorID, quote.ID, quote.Version}
+
+   err = tx.QueryRowContext(ctx, query, args...).Scan(&quote.Version, &quote.UpdatedAt)
+   // no row came back so the version (or the quote) is gone
+   if err != nil {
+       switch {
+           case errors.Is(err, sql.ErrNoRows):
+               return ErrEditConflict
+           default:
+               return err
+       }
+   }
 
+   err = setQuoteTags(ctx, tx, quote.ID, quote.Tags)
+   if err != nil {
+       return err
+   }
+
+   return tx.Commit()
 }
 
 // Delete a specific Quote from the quotes table
-func (q QuoteModel) Delete(id int64) error {
+func (q QuoteModel) Delete(ctx context.Context, id int64) error {
 
     // check if the id is valid
     if id < 1 {
@@ -133,7 +291,7 @@ func (q QuoteModel) Delete(id int64) error {
         WHERE id = $1
       `
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	ctx, cancel := q.queryContext(ctx)
    defer cancel()
 
 // ExecContext does not return any rows unlike QueryRowContext. 
@@ -160,25 +318,24 @@ func (q QuoteModel) Delete(id int64) error {
 }
 
 // Get all comments
-func (q QuoteModel) GetAll(content string, author string, filters Filters) ([]*Quotes, Metadata, error) {
+func (q QuoteModel) GetAll(ctx context.Context, search QuoteSearch, filters Filters) ([]*Quotes, Metadata, error) {
 
+	where, args := search.where()
 	// the SQL query to be executed against the database table
     query := fmt.Sprintf(`
-        SELECT COUNT(*) OVER(), id, created_at, content, author, version
-        FROM quotes
-        WHERE (to_tsvector('simple', content) @@
-              plainto_tsquery('simple', $1) OR $1 = '') 
-        AND (to_tsvector('simple', author) @@ 
-             plainto_tsquery('simple', $2) OR $2 = '') 
+        SELECT COUNT(*) OVER(), %s
+        FROM %s
+        %s
         ORDER BY %s %s, id ASC  
-		LIMIT $3 OFFSET $4
-     `, filters.SortColumn(), filters.SortDirection())
+		LIMIT $%d OFFSET $%d
+     `, quoteColumns, quoteTables, where, filters.SortColumn(), filters.SortDirection(), len(args)+1, len(args)+2)
+	args = append(args, filters.Limit(), filters.Offset())
 
-   ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+   ctx, cancel := q.queryContext(ctx)
    defer cancel()
 
    // QueryContext returns multiple rows.
-	rows, err := q.DB.QueryContext(ctx, query, content, author, filters.Limit(), filters.Offset())
+	rows, err := q.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -193,13 +350,7 @@ func (q QuoteModel) GetAll(content string, author string, filters Filters) ([]*Q
 
 	for rows.Next() {
 		var quote Quotes
-		err := rows.Scan(&totalRecords,
-						&quote.ID,
-						&quote.CreatedAt,
-						&quote.Content,
-						&quote.Author,
-						&quote.Version,
-						)
+		err := rows.Scan(append([]any{&totalRecords}, quote.scanTargets()...)...)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
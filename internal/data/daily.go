@@ -0,0 +1,153 @@
+// Filename: internal/data/daily.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"hash/fnv"
+	"time"
+)
+
+// the format of the day column (and of the dates in the API)
+const DayLayout = "2006-01-02"
+
+// DailyQuote is the quote selected (or pinned) for one calendar day
+type DailyQuote struct {
+	Day    time.Time
+	Pinned bool
+	Quote  *Quotes
+}
+
+// Every day gets a position among the candidate quotes. Hashing the date
+// means everyone asking about the same day gets the same quote while
+// consecutive days jump around the collection
+func dailyIndex(day time.Time, candidates int) int {
+	h := fnv.New64a()
+	h.Write([]byte(day.Format(DayLayout)))
+	return int(h.Sum64() % uint64(candidates))
+}
+
+// GetDaily returns the quote of the given day. The first time a day is
+// asked for we pick a quote, skipping the ones selected within window days
+// of it, and record the choice so it stays the same for the rest of the day
+func (q QuoteModel) GetDaily(ctx context.Context, day time.Time, window int) (*DailyQuote, error) {
+	daily, err := q.getRecordedDaily(ctx, day)
+	if !errors.Is(err, ErrRecordNotFound) {
+		return daily, err
+	}
+
+	quoteID, err := q.pickDaily(ctx, day, window)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+        INSERT INTO daily_quotes (day, quote_id)
+        VALUES ($1::date, $2)
+        ON CONFLICT (day) DO NOTHING
+      `
+	insertCtx, cancel := q.queryContext(ctx)
+	defer cancel()
+
+	_, err = q.DB.ExecContext(insertCtx, query, day.Format(DayLayout), quoteID)
+	if err != nil {
+		return nil, err
+	}
+
+	// read it back, if another request beat us to it we use its choice
+	return q.getRecordedDaily(ctx, day)
+}
+
+// read the quote already recorded for a day
+func (q QuoteModel) getRecordedDaily(ctx context.Context, day time.Time) (*DailyQuote, error) {
+	query := `
+        SELECT d.pinned, ` + quoteColumns + `
+        FROM daily_quotes d
+        INNER JOIN quotes ON quotes.id = d.quote_id
+        INNER JOIN authors ON authors.id = quotes.author_id
+        WHERE d.day = $1::date
+      `
+	ctx, cancel := q.queryContext(ctx)
+	defer cancel()
+
+	daily := DailyQuote{Day: day, Quote: &Quotes{}}
+	targets := append([]any{&daily.Pinned}, daily.Quote.scanTargets()...)
+	err := q.DB.QueryRowContext(ctx, query, day.Format(DayLayout)).Scan(targets...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &daily, nil
+}
+
+// choose the quote for a day without loading every id: count the
+// candidates and then jump straight to the one at the day's position
+func (q QuoteModel) pickDaily(ctx context.Context, day time.Time, window int) (int64, error) {
+	// the quotes selected (or pinned) within window days either side
+	// of the day are not candidates. A negative window excludes nothing
+	candidates := `
+        FROM quotes
+        WHERE id NOT IN (
+            SELECT quote_id FROM daily_quotes
+            WHERE day BETWEEN $1::date - $2::int AND $1::date + $2::int
+        )
+      `
+
+	ctx, cancel := q.queryContext(ctx)
+	defer cancel()
+
+	var total int
+	err := q.DB.QueryRowContext(ctx, "SELECT COUNT(*)"+candidates, day.Format(DayLayout), window).Scan(&total)
+	if err != nil {
+		return 0, err
+	}
+
+	if total == 0 {
+		// every quote was shown recently so we have to repeat one
+		if window >= 0 {
+			return q.pickDaily(ctx, day, -1)
+		}
+		return 0, ErrRecordNotFound
+	}
+
+	var quoteID int64
+	query := "SELECT id" + candidates + " ORDER BY id LIMIT 1 OFFSET $3"
+	err = q.DB.QueryRowContext(ctx, query, day.Format(DayLayout), window, dailyIndex(day, total)).Scan(&quoteID)
+	if err != nil {
+		return 0, err
+	}
+	return quoteID, nil
+}
+
+// PinDaily makes quoteID the quote of the given day,
+// replacing anything chosen for that day before
+func (q QuoteModel) PinDaily(ctx context.Context, day time.Time, quoteID int64) error {
+	query := `
+        INSERT INTO daily_quotes (day, quote_id, pinned)
+        SELECT $1::date, id, true FROM quotes WHERE id = $2
+        ON CONFLICT (day) DO UPDATE
+        SET quote_id = EXCLUDED.quote_id, pinned = true, created_at = NOW()
+      `
+	ctx, cancel := q.queryContext(ctx)
+	defer cancel()
+
+	result, err := q.DB.ExecContext(ctx, query, day.Format(DayLayout), quoteID)
+	if err != nil {
+		return err
+	}
+
+	// nothing was inserted so the quote does not exist
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
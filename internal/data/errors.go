@@ -2,6 +2,27 @@ package data
 
 import (
 	"errors"
+
+	"github.com/lib/pq"
 )
 
 var ErrRecordNotFound = errors.New("record not found")
+
+// returned when an update loses the race against another writer
+// (the version the client read is no longer the stored version)
+var ErrEditConflict = errors.New("edit conflict")
+
+// the author_id given for a quote does not exist
+var ErrAuthorNotFound = errors.New("author not found")
+
+// another author already has that name
+var ErrDuplicateAuthor = errors.New("duplicate author")
+
+// the author can't be deleted while quotes are attributed to them
+var ErrAuthorHasQuotes = errors.New("author has quotes")
+
+// is err PostgreSQL rejecting a row because of the given unique constraint
+func isUniqueViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == constraint
+}
@@ -0,0 +1,147 @@
+// Filename: internal/data/export.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// the rows read from the export cursor at a time
+const exportBatchSize = 500
+
+// Export calls fn with every quote matching the search, in the order
+// of the filters' sort (the page and page size are not used). The rows
+// come from a cursor so only one batch is held in memory at a time
+func (q QuoteModel) Export(ctx context.Context, search QuoteSearch, filters Filters, fn func(quote *Quotes) error) error {
+	where, args := search.where()
+	query := fmt.Sprintf(`
+        DECLARE quotes_export NO SCROLL CURSOR FOR
+        SELECT %s
+        FROM %s
+        %s
+        ORDER BY %s %s, id ASC
+      `, quoteColumns, quoteTables, where, filters.SortColumn(), filters.SortDirection())
+
+	// a single snapshot, the quotes added or changed while a long
+	// export runs don't show up half way through it
+	tx, err := q.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	declareCtx, cancel := q.queryContext(ctx)
+	_, err = tx.ExecContext(declareCtx, query, args...)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	for {
+		quotes, err := q.fetchExportBatch(ctx, tx)
+		if err != nil {
+			return err
+		}
+		// the batch is read before fn sees it so a slow
+		// client doesn't count against the query timeout
+		for _, quote := range quotes {
+			err := fn(quote)
+			if err != nil {
+				return err
+			}
+		}
+		if len(quotes) < exportBatchSize {
+			return nil
+		}
+	}
+}
+
+func (q QuoteModel) fetchExportBatch(ctx context.Context, tx *sql.Tx) ([]*Quotes, error) {
+	ctx, cancel := q.queryContext(ctx)
+	defer cancel()
+
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`FETCH %d FROM quotes_export`, exportBatchSize))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	quotes := make([]*Quotes, 0, exportBatchSize)
+	for rows.Next() {
+		var quote Quotes
+		err := rows.Scan(quote.scanTargets()...)
+		if err != nil {
+			return nil, err
+		}
+		quotes = append(quotes, &quote)
+	}
+	return quotes, rows.Err()
+}
+
+// the formats a QuoteWriter can write
+var ExportFormats = []string{"jsonl", "csv"}
+
+// QuoteWriter writes quotes one at a time in one of the ExportFormats.
+// Flush pushes out what is buffered and reports the errors so far
+type QuoteWriter interface {
+	Write(quote *Quotes) error
+	Flush() error
+}
+
+// NewQuoteWriter writes JSON Lines or CSV. The CSV starts with a header
+// and puts the tags in one column separated by commas, which is what
+// qodadmin import reads back
+func NewQuoteWriter(w io.Writer, format string) (QuoteWriter, error) {
+	switch format {
+	case "jsonl":
+		return jsonLinesWriter{enc: json.NewEncoder(w)}, nil
+	case "csv":
+		writer := csvQuoteWriter{w: csv.NewWriter(w)}
+		err := writer.w.Write(csvQuoteHeader)
+		return writer, err
+	default:
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+type jsonLinesWriter struct {
+	enc *json.Encoder
+}
+
+func (j jsonLinesWriter) Write(quote *Quotes) error {
+	return j.enc.Encode(quote)
+}
+
+// every line is written as soon as it is encoded
+func (j jsonLinesWriter) Flush() error {
+	return nil
+}
+
+var csvQuoteHeader = []string{"id", "content", "author", "author_id", "tags", "created_by", "version"}
+
+type csvQuoteWriter struct {
+	w *csv.Writer
+}
+
+func (c csvQuoteWriter) Write(quote *Quotes) error {
+	return c.w.Write([]string{
+		strconv.FormatInt(quote.ID, 10),
+		quote.Content,
+		quote.Author,
+		strconv.FormatInt(quote.AuthorID, 10),
+		strings.Join(quote.Tags, ","),
+		strconv.FormatInt(quote.CreatedBy, 10),
+		strconv.FormatInt(int64(quote.Version), 10),
+	})
+}
+
+func (c csvQuoteWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
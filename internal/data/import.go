@@ -0,0 +1,203 @@
+// Filename: internal/data/import.go
+package data
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// ImportRow is a quote read from a dataset and the line it came from
+type ImportRow struct {
+	Line  int
+	Quote *Quotes
+}
+
+// ImportResult says what an import did (or would have done)
+type ImportResult struct {
+	Inserted int
+	// the lines skipped because the author already has the quote,
+	// in the database or earlier in the dataset
+	Duplicates []int
+}
+
+// the spaces around the content of a quote that don't make it another
+// quote. The duplicates are found by the content without them, in the
+// dataset and in the database alike (btrim gets the same set)
+const importSpace = " \t\n\v\f\r"
+
+// Import adds validated quotes in bulk. The rows are copied into a
+// temporary table and moved into the quotes, authors and tags from
+// there, all in one transaction. With dryRun we stop once we know the
+// duplicates: nothing is written and no ids are taken from the
+// sequences (a rollback doesn't give those back), but the result
+// is the same
+func (q QuoteModel) Import(ctx context.Context, rows []ImportRow, dryRun bool) (ImportResult, error) {
+	var result ImportResult
+
+	// the same quote twice in the dataset is only copied once
+	seen := map[[2]string]bool{}
+	unique := []ImportRow{}
+	for _, row := range rows {
+		key := [2]string{strings.TrimSpace(row.Quote.Author), strings.Trim(row.Quote.Content, importSpace)}
+		if seen[key] {
+			result.Duplicates = append(result.Duplicates, row.Line)
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, row)
+	}
+
+	ctx, cancel := q.queryContext(ctx)
+	defer cancel()
+
+	tx, err := q.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+        CREATE TEMPORARY TABLE quotes_import (
+            line integer NOT NULL,
+            content text NOT NULL,
+            author text NOT NULL,
+            tags text[] NOT NULL,
+            duplicate boolean NOT NULL DEFAULT false,
+            quote_id bigint
+        ) ON COMMIT DROP
+      `)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("quotes_import", "line", "content", "author", "tags"))
+	if err != nil {
+		return ImportResult{}, err
+	}
+	for _, row := range unique {
+		_, err = stmt.ExecContext(ctx, row.Line, row.Quote.Content,
+			strings.TrimSpace(row.Quote.Author), pq.Array(row.Quote.Tags))
+		if err != nil {
+			stmt.Close()
+			return ImportResult{}, err
+		}
+	}
+	// an Exec without arguments flushes the COPY
+	_, err = stmt.ExecContext(ctx)
+	if err != nil {
+		stmt.Close()
+		return ImportResult{}, err
+	}
+	err = stmt.Close()
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	// the quotes we already have
+	_, err = tx.ExecContext(ctx, `
+        UPDATE quotes_import i
+        SET duplicate = true
+        WHERE EXISTS (
+            SELECT 1 FROM `+quoteTables+`
+            WHERE authors.name = i.author AND btrim(quotes.content, $1) = btrim(i.content, $1)
+        )
+      `, importSpace)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	existing, err := tx.QueryContext(ctx, `SELECT line FROM quotes_import WHERE duplicate`)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	defer existing.Close()
+	for existing.Next() {
+		var line int
+		err := existing.Scan(&line)
+		if err != nil {
+			return ImportResult{}, err
+		}
+		result.Duplicates = append(result.Duplicates, line)
+	}
+	err = existing.Err()
+	if err != nil {
+		return ImportResult{}, err
+	}
+	slices.Sort(result.Duplicates)
+
+	if dryRun {
+		err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM quotes_import WHERE NOT duplicate`).Scan(&result.Inserted)
+		if err != nil {
+			return ImportResult{}, err
+		}
+		return result, nil
+	}
+
+	// the id each new quote will be inserted with, so its tags can follow
+	// it. An UPDATE takes the rows in no particular order, the ids are
+	// drawn over the lines in order so they follow the dataset
+	_, err = tx.ExecContext(ctx, `
+        UPDATE quotes_import i
+        SET quote_id = numbered.quote_id
+        FROM (
+            SELECT line, nextval(pg_get_serial_sequence('quotes', 'id')) AS quote_id
+            FROM (SELECT line FROM quotes_import WHERE NOT duplicate ORDER BY line) AS ordered
+        ) AS numbered
+        WHERE i.line = numbered.line
+      `)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO authors (name)
+        SELECT DISTINCT author FROM quotes_import WHERE NOT duplicate
+        ON CONFLICT (name) DO NOTHING
+      `)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	inserted, err := tx.ExecContext(ctx, `
+        INSERT INTO quotes (id, content, author_id)
+        SELECT i.quote_id, i.content, authors.id
+        FROM quotes_import i
+        INNER JOIN authors ON authors.name = i.author
+        WHERE NOT i.duplicate
+        ORDER BY i.line
+      `)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	rowsAffected, err := inserted.RowsAffected()
+	if err != nil {
+		return ImportResult{}, err
+	}
+	result.Inserted = int(rowsAffected)
+
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO tags (name)
+        SELECT DISTINCT unnest(tags) FROM quotes_import WHERE NOT duplicate
+        ON CONFLICT (name) DO NOTHING
+      `)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO quotes_tags (quote_id, tag_id)
+        SELECT DISTINCT i.quote_id, tags.id
+        FROM quotes_import i
+        CROSS JOIN LATERAL unnest(i.tags) AS tag(name)
+        INNER JOIN tags ON tags.name = tag.name
+        WHERE NOT i.duplicate
+      `)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	return result, tx.Commit()
+}
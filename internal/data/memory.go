@@ -0,0 +1,434 @@
+// Filename: internal/data/memory.go
+package data
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// MemoryQuoteStore keeps the quotes in memory. It behaves like QuoteModel
+// (ids, versions, filtering, sorting and pagination) but everything is
+// lost when the process stops
+type MemoryQuoteStore struct {
+	mu     sync.RWMutex
+	nextID int64
+	quotes map[int64]*Quotes
+	// the daily_quotes table, keyed by day
+	daily map[string]memoryDailyQuote
+	// the authors table, both ways round
+	nextAuthorID int64
+	authorNames  map[int64]string
+	authorIDs    map[string]int64
+}
+
+type memoryDailyQuote struct {
+	quoteID int64
+	pinned  bool
+}
+
+// Construct an empty in-memory store
+func NewMemoryQuoteStore() *MemoryQuoteStore {
+	return &MemoryQuoteStore{
+		nextID: 1,
+		quotes: make(map[int64]*Quotes),
+		daily:  make(map[string]memoryDailyQuote),
+
+		nextAuthorID: 1,
+		authorNames:  make(map[int64]string),
+		authorIDs:    make(map[string]int64),
+	}
+}
+
+// Insert a new quote. Like the database we fill in the id,
+// created_at, updated_at and version
+func (m *MemoryQuoteStore) Insert(ctx context.Context, quote *Quotes) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	err := m.resolveAuthor(quote)
+	if err != nil {
+		return err
+	}
+
+	quote.ID = m.nextID
+	// created_at is a TIMESTAMP(0) column so there are no fractions of a second
+	quote.CreatedAt = time.Now().Truncate(time.Second)
+	quote.UpdatedAt = quote.CreatedAt
+	quote.Version = 1
+	m.nextID++
+
+	stored := *quote
+	stored.Tags = slices.Clone(quote.Tags)
+	m.quotes[quote.ID] = &stored
+	return nil
+}
+
+// Get a copy of a specific quote
+func (m *MemoryQuoteStore) Get(ctx context.Context, id int64) (*Quotes, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stored, found := m.quotes[id]
+	if !found {
+		return nil, ErrRecordNotFound
+	}
+	return copyQuote(stored), nil
+}
+
+// Update a quote if it still has the version the caller read
+func (m *MemoryQuoteStore) Update(ctx context.Context, quote *Quotes) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, found := m.quotes[quote.ID]
+	if !found || stored.Version != quote.Version {
+		return ErrEditConflict
+	}
+
+	err := m.resolveAuthor(quote)
+	if err != nil {
+		return err
+	}
+
+	quote.Version++
+	quote.UpdatedAt = time.Now().Truncate(time.Second)
+	updated := *quote
+	updated.Tags = slices.Clone(quote.Tags)
+	// the creation time and the creator never change
+	updated.CreatedAt = stored.CreatedAt
+	updated.CreatedBy = stored.CreatedBy
+	m.quotes[quote.ID] = &updated
+	return nil
+}
+
+// Delete a specific quote
+func (m *MemoryQuoteStore) Delete(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, found := m.quotes[id]; !found {
+		return ErrRecordNotFound
+	}
+	delete(m.quotes, id)
+
+	// ON DELETE CASCADE
+	for day, daily := range m.daily {
+		if daily.quoteID == id {
+			delete(m.daily, day)
+		}
+	}
+	return nil
+}
+
+// Get all the quotes matching the content and author filters,
+// sorted and paginated the same way QuoteModel.GetAll does it
+func (m *MemoryQuoteStore) GetAll(ctx context.Context, search QuoteSearch, filters Filters) ([]*Quotes, Metadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	matches := m.search(search, filters.SortColumn(), filters.SortDirection() == "DESC")
+
+	totalRecords := len(matches)
+	start := min(filters.Offset(), totalRecords)
+	end := min(start+filters.Limit(), totalRecords)
+
+	metadata := CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return matches[start:end], metadata, nil
+}
+
+// call fn with every quote matching the search, in the
+// order QuoteModel.Export gives them
+func (m *MemoryQuoteStore) Export(ctx context.Context, search QuoteSearch, filters Filters, fn func(quote *Quotes) error) error {
+	for _, quote := range m.search(search, filters.SortColumn(), filters.SortDirection() == "DESC") {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := fn(quote)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get up to count random quotes matching the search, picking the
+// same positions QuoteModel.GetRandom would for the same seed
+func (m *MemoryQuoteStore) GetRandom(ctx context.Context, search QuoteSearch, count int, seed int64) ([]*Quotes, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// the matches sorted by id, the way QuoteModel.GetRandom
+	// numbers them, and the positions it draws for the seed
+	matches := m.search(search, "id", false)
+	positions := randomPositions(int64(len(matches)), count, seed)
+	quotes := make([]*Quotes, 0, len(positions))
+	for _, position := range positions {
+		quotes = append(quotes, matches[position])
+	}
+	return quotes, nil
+}
+
+// Get (or choose and record) the quote of the given day,
+// picking the same quote QuoteModel.GetDaily would
+func (m *MemoryQuoteStore) GetDaily(ctx context.Context, day time.Time, window int) (*DailyQuote, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := day.Format(DayLayout)
+	daily, found := m.daily[key]
+	if !found {
+		quoteID, err := m.pickDaily(day, window)
+		if err != nil {
+			return nil, err
+		}
+		daily = memoryDailyQuote{quoteID: quoteID}
+		m.daily[key] = daily
+	}
+
+	quote := copyQuote(m.quotes[daily.quoteID])
+	return &DailyQuote{Day: day, Pinned: daily.pinned, Quote: quote}, nil
+}
+
+// the caller must hold the lock
+func (m *MemoryQuoteStore) pickDaily(day time.Time, window int) (int64, error) {
+	// the quotes shown within window days either side of the day
+	recent := make(map[int64]bool)
+	for offset := -window; offset <= window; offset++ {
+		daily, found := m.daily[day.AddDate(0, 0, offset).Format(DayLayout)]
+		if found {
+			recent[daily.quoteID] = true
+		}
+	}
+
+	candidates := []int64{}
+	for id := range m.quotes {
+		if !recent[id] {
+			candidates = append(candidates, id)
+		}
+	}
+
+	if len(candidates) == 0 {
+		// every quote was shown recently so we have to repeat one
+		if window >= 0 {
+			return m.pickDaily(day, -1)
+		}
+		return 0, ErrRecordNotFound
+	}
+
+	// ORDER BY id LIMIT 1 OFFSET <the day's position>
+	slices.Sort(candidates)
+	return candidates[dailyIndex(day, len(candidates))], nil
+}
+
+// Pin a quote to a day, replacing anything chosen for that day before
+func (m *MemoryQuoteStore) PinDaily(ctx context.Context, day time.Time, quoteID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, found := m.quotes[quoteID]; !found {
+		return ErrRecordNotFound
+	}
+	m.daily[day.Format(DayLayout)] = memoryDailyQuote{quoteID: quoteID, pinned: true}
+	return nil
+}
+
+// the in-memory version of resolveAuthor(), the caller must hold the lock
+func (m *MemoryQuoteStore) resolveAuthor(quote *Quotes) error {
+	if quote.AuthorID > 0 {
+		name, found := m.authorNames[quote.AuthorID]
+		if !found {
+			return ErrAuthorNotFound
+		}
+		quote.Author = name
+		return nil
+	}
+
+	quote.Author = strings.TrimSpace(quote.Author)
+	id, found := m.authorIDs[quote.Author]
+	if !found {
+		id = m.nextAuthorID
+		m.nextAuthorID++
+		m.authorIDs[quote.Author] = id
+		m.authorNames[id] = quote.Author
+	}
+	quote.AuthorID = id
+	return nil
+}
+
+// List the tags in use, the most used first
+func (m *MemoryQuoteStore) ListTags(ctx context.Context) ([]*Tag, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	counts := make(map[string]int)
+	for _, quote := range m.quotes {
+		for _, tag := range quote.Tags {
+			counts[tag]++
+		}
+	}
+	m.mu.RUnlock()
+
+	tags := []*Tag{}
+	for name, count := range counts {
+		tags = append(tags, &Tag{Name: name, QuoteCount: count})
+	}
+	// ORDER BY COUNT(...) DESC, name ASC
+	slices.SortFunc(tags, func(a, b *Tag) int {
+		if a.QuoteCount != b.QuoteCount {
+			return b.QuoteCount - a.QuoteCount
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return tags, nil
+}
+
+// a copy the caller can change without touching the stored quote
+func copyQuote(stored *Quotes) *Quotes {
+	quote := *stored
+	quote.Tags = slices.Clone(stored.Tags)
+	if quote.Tags == nil {
+		quote.Tags = []string{}
+	}
+	return &quote
+}
+
+// copies of the quotes matching the search, sorted by
+// ORDER BY <column> <direction>, id ASC
+func (m *MemoryQuoteStore) search(search QuoteSearch, column string, descending bool) []*Quotes {
+	m.mu.RLock()
+	matches := []*Quotes{}
+	for _, stored := range m.quotes {
+		if !search.matches(stored) {
+			continue
+		}
+		matches = append(matches, copyQuote(stored))
+	}
+	m.mu.RUnlock()
+
+	slices.SortFunc(matches, func(a, b *Quotes) int {
+		result := compareQuotes(a, b, column)
+		if descending {
+			result = -result
+		}
+		if result == 0 {
+			result = compareInt64(a.ID, b.ID)
+		}
+		return result
+	})
+	return matches
+}
+
+// compare two quotes on one of the sortable columns
+func compareQuotes(a, b *Quotes, column string) int {
+	switch column {
+	case "created_at":
+		return a.CreatedAt.Compare(b.CreatedAt)
+	case "author":
+		return strings.Compare(a.Author, b.Author)
+	default:
+		return compareInt64(a.ID, b.ID)
+	}
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// the in-memory version of QuoteSearch.where()
+func (s QuoteSearch) matches(quote *Quotes) bool {
+	if !matchesText(quote.Content, s.Content) || !matchesText(quote.Author, s.Author) {
+		return false
+	}
+	if s.AuthorID > 0 && quote.AuthorID != s.AuthorID {
+		return false
+	}
+
+	tags := NormalizeTags(s.Tags)
+	if len(tags) == 0 {
+		return true
+	}
+	found := 0
+	for _, tag := range tags {
+		if slices.Contains(quote.Tags, tag) {
+			found++
+		}
+	}
+	if s.MatchAllTags {
+		return found == len(tags)
+	}
+	return found > 0
+}
+
+// matchesText mimics
+//
+//	to_tsvector('simple', text) @@ plainto_tsquery('simple', query) OR query = ''
+//
+// every word of the query must appear as a word of the text,
+// ignoring case and punctuation
+func matchesText(text string, query string) bool {
+	words := textSearchWords(query)
+	if len(words) == 0 {
+		return true
+	}
+
+	available := textSearchWords(text)
+	for _, word := range words {
+		if !slices.Contains(available, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// split the text into lowercase words the way the 'simple' configuration does
+func textSearchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
@@ -0,0 +1,368 @@
+// Filename: internal/data/memory_accounts.go
+package data
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryAccounts keeps the users, their tokens, API keys and permissions in
+// memory so that -storage=memory (and the handler tests) can log in.
+// The three stores share one lock, like the tables share one database
+type MemoryAccounts struct {
+	mu          sync.RWMutex
+	nextUserID  int64
+	users       map[int64]*User
+	tokens      map[string]*Token // keyed by the hash
+	permissions map[int64]Permissions
+	nextKeyID   int64
+	apiKeys     map[int64]*APIKey
+}
+
+// Construct empty in-memory accounts
+func NewMemoryAccounts() *MemoryAccounts {
+	return &MemoryAccounts{
+		nextUserID:  1,
+		users:       make(map[int64]*User),
+		tokens:      make(map[string]*Token),
+		permissions: make(map[int64]Permissions),
+		nextKeyID:   1,
+		apiKeys:     make(map[int64]*APIKey),
+	}
+}
+
+// the views of MemoryAccounts as each of the stores
+type memoryUsers struct{ *MemoryAccounts }
+type memoryTokens struct{ *MemoryAccounts }
+type memoryPermissions struct{ *MemoryAccounts }
+type memoryAPIKeys struct{ *MemoryAccounts }
+
+func (m *MemoryAccounts) Users() UserStore             { return memoryUsers{m} }
+func (m *MemoryAccounts) Tokens() TokenStore           { return memoryTokens{m} }
+func (m *MemoryAccounts) Permissions() PermissionStore { return memoryPermissions{m} }
+func (m *MemoryAccounts) APIKeys() APIKeyStore         { return memoryAPIKeys{m} }
+
+// the caller must hold the lock. Emails are compared ignoring case (citext)
+func (m *MemoryAccounts) findEmail(email string) *User {
+	for _, user := range m.users {
+		if strings.EqualFold(user.Email, email) {
+			return user
+		}
+	}
+	return nil
+}
+
+func (m memoryUsers) Insert(ctx context.Context, user *User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.insertUser(user)
+}
+
+// the user, their permissions and the token are added under one
+// lock, nobody sees the account before it is complete
+func (m memoryUsers) Register(ctx context.Context, user *User, permissions []string, activationTTL time.Duration) (*Token, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	err := m.insertUser(user)
+	if err != nil {
+		return nil, err
+	}
+	m.addPermissions(user.ID, permissions)
+
+	token := generateToken(user.ID, activationTTL, ScopeActivation)
+	m.insertToken(token)
+	return token, nil
+}
+
+// the caller must hold the lock
+func (m *MemoryAccounts) insertUser(user *User) error {
+	if m.findEmail(user.Email) != nil {
+		return ErrDuplicateEmail
+	}
+
+	user.ID = m.nextUserID
+	user.CreatedAt = time.Now().Truncate(time.Second)
+	user.Version = 1
+	m.nextUserID++
+
+	stored := *user
+	m.users[user.ID] = &stored
+	return nil
+}
+
+func (m memoryUsers) Get(ctx context.Context, id int64) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stored, found := m.users[id]
+	if !found {
+		return nil, ErrRecordNotFound
+	}
+	user := *stored
+	return &user, nil
+}
+
+func (m memoryUsers) GetByEmail(ctx context.Context, email string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stored := m.findEmail(email)
+	if stored == nil {
+		return nil, ErrRecordNotFound
+	}
+	user := *stored
+	return &user, nil
+}
+
+func (m memoryUsers) Update(ctx context.Context, user *User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, found := m.users[user.ID]
+	if !found || stored.Version != user.Version {
+		return ErrEditConflict
+	}
+	if other := m.findEmail(user.Email); other != nil && other.ID != user.ID {
+		return ErrDuplicateEmail
+	}
+
+	user.Version++
+	updated := *user
+	m.users[user.ID] = &updated
+	return nil
+}
+
+func (m memoryUsers) GetForToken(ctx context.Context, tokenScope string, tokenPlaintext string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	token, found := m.tokens[string(hashToken(tokenPlaintext))]
+	if !found || token.Scope != tokenScope || !token.Expiry.After(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+	stored, found := m.users[token.UserID]
+	if !found {
+		return nil, ErrRecordNotFound
+	}
+	user := *stored
+	return &user, nil
+}
+
+func (m memoryTokens) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token := generateToken(userID, ttl, scope)
+	err := m.Insert(ctx, token)
+	return token, err
+}
+
+func (m memoryTokens) Insert(ctx context.Context, token *Token) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.insertToken(token)
+	return nil
+}
+
+// the caller must hold the lock. We only keep what the tokens table keeps
+func (m *MemoryAccounts) insertToken(token *Token) {
+	m.tokens[string(token.Hash)] = &Token{
+		Hash:   token.Hash,
+		UserID: token.UserID,
+		Expiry: token.Expiry,
+		Scope:  token.Scope,
+	}
+}
+
+func (m memoryTokens) Delete(ctx context.Context, scope string, tokenPlaintext string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := string(hashToken(tokenPlaintext))
+	if token, found := m.tokens[key]; found && token.Scope == scope {
+		delete(m.tokens, key)
+	}
+	return nil
+}
+
+func (m memoryTokens) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, token := range m.tokens {
+		if token.Scope == scope && token.UserID == userID {
+			delete(m.tokens, key)
+		}
+	}
+	return nil
+}
+
+func (m memoryTokens) DeleteExpired(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deleted int64
+	now := time.Now()
+	for key, token := range m.tokens {
+		if token.Expiry.Before(now) {
+			delete(m.tokens, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (m memoryPermissions) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	permissions := slices.Clone(m.permissions[userID])
+	if permissions == nil {
+		permissions = Permissions{}
+	}
+	return permissions, nil
+}
+
+func (m memoryPermissions) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.addPermissions(userID, codes)
+	return nil
+}
+
+// the caller must hold the lock
+func (m *MemoryAccounts) addPermissions(userID int64, codes []string) {
+	for _, code := range codes {
+		if !m.permissions[userID].Include(code) {
+			m.permissions[userID] = append(m.permissions[userID], code)
+		}
+	}
+}
+
+func (m memoryAPIKeys) Insert(ctx context.Context, key *APIKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key.ID = m.nextKeyID
+	key.CreatedAt = time.Now().Truncate(time.Second)
+	m.nextKeyID++
+
+	// like the api_keys table we don't keep the plaintext
+	stored := *key
+	stored.Plaintext = ""
+	m.apiKeys[key.ID] = &stored
+	return nil
+}
+
+func (m memoryAPIKeys) GetByPlaintext(ctx context.Context, plaintext string) (*APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	hash := hashToken(plaintext)
+	for _, stored := range m.apiKeys {
+		if bytes.Equal(stored.Hash, hash) {
+			key := *stored
+			return &key, nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+func (m memoryAPIKeys) GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := []*APIKey{}
+	for _, stored := range m.apiKeys {
+		if stored.UserID == userID {
+			key := *stored
+			keys = append(keys, &key)
+		}
+	}
+	slices.SortFunc(keys, func(a, b *APIKey) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return keys, nil
+}
+
+func (m memoryAPIKeys) Delete(ctx context.Context, id int64, userID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, found := m.apiKeys[id]
+	if !found || stored.UserID != userID {
+		return ErrRecordNotFound
+	}
+	delete(m.apiKeys, id)
+	return nil
+}
@@ -0,0 +1,82 @@
+// Filename: internal/data/permissions.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// the permission codes
+const (
+	// the quotes are open to everyone, this is for reading them
+	// in bulk (the export)
+	PermissionQuotesRead  = "quotes:read"
+	PermissionQuotesWrite = "quotes:write"
+	// edit and delete quotes created by other users, pin daily quotes
+	PermissionQuotesAdmin = "quotes:admin"
+)
+
+// Permissions are the codes granted to one user
+type Permissions []string
+
+// Include reports whether the code was granted
+func (p Permissions) Include(code string) bool {
+	return slices.Contains(p, code)
+}
+
+// A PermissionModel expects a connection pool
+type PermissionModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// GetAllForUser returns the permission codes of a user
+func (m PermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	query := `
+        SELECT permissions.code
+        FROM permissions
+        INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+        WHERE users_permissions.user_id = $1
+      `
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := Permissions{}
+	for rows.Next() {
+		var code string
+		err := rows.Scan(&code)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, code)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// AddForUser grants the codes to a user (granting one twice is fine)
+func (m PermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	query := `
+        INSERT INTO users_permissions (user_id, permission_id)
+        SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+        ON CONFLICT DO NOTHING
+      `
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
@@ -0,0 +1,242 @@
+// Filename: internal/data/postgres_internal_test.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Lee26Ed/qod/internal/migrate"
+	"github.com/Lee26Ed/qod/migrations"
+	_ "github.com/lib/pq"
+)
+
+// newTestDB connects to the PostgreSQL database in QUOTES_TEST_DB_DSN, migrates
+// it and empties the quote and user tables. The tests using it are skipped when
+// the variable is not set. Point it at a throwaway database, never at
+// one whose data you want to keep
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("QUOTES_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("QUOTES_TEST_DB_DSN is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = migrator.Up(context.Background())
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		t.Fatal(err)
+	}
+
+	truncate := func() {
+		_, err := db.Exec(`TRUNCATE quotes, authors, tags, daily_quotes, users RESTART IDENTITY CASCADE`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	truncate()
+	t.Cleanup(truncate)
+
+	return db
+}
+
+func TestQuoteModelGetRandom(t *testing.T) {
+	db := newTestDB(t)
+	quotes := QuoteModel{DB: db}
+	memory := NewMemoryQuoteStore()
+	ctx := context.Background()
+
+	for _, author := range []string{"Seneca", "Epictetus", "Zeno", "Marcus Aurelius", "Cicero"} {
+		for _, store := range []QuoteStore{quotes, memory} {
+			quote := &Quotes{Content: "Be still", Author: author, Tags: []string{"stoic"}}
+			err := store.Insert(ctx, quote)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	ids := func(quotes []*Quotes) []int64 {
+		result := []int64{}
+		for _, quote := range quotes {
+			result = append(result, quote.ID)
+		}
+		return result
+	}
+
+	first, err := quotes.GetRandom(ctx, QuoteSearch{}, 3, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 3 {
+		t.Fatalf("expected 3 quotes, got %d", len(first))
+	}
+	for _, quote := range first {
+		if quote.Author == "" || quote.UpdatedAt.IsZero() || !slices.Equal(quote.Tags, []string{"stoic"}) {
+			t.Errorf("expected every column to be read, got %+v", quote)
+		}
+	}
+
+	second, err := quotes.GetRandom(ctx, QuoteSearch{}, 3, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ids(second), ids(first)) {
+		t.Errorf("expected the same quotes for the same seed, got %v and %v", ids(first), ids(second))
+	}
+
+	// the memory store must pick what the database picks
+	picked, err := memory.GetRandom(ctx, QuoteSearch{}, 3, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ids(picked), ids(first)) {
+		t.Errorf("expected the memory store to pick %v, got %v", ids(first), ids(picked))
+	}
+
+	got, err := quotes.GetRandom(ctx, QuoteSearch{Author: "zeno"}, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ids(got), []int64{3}) {
+		t.Errorf("expected only quote 3 to match, got %v", ids(got))
+	}
+
+	got, err = quotes.GetRandom(ctx, QuoteSearch{Author: "plato"}, 3, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("expected no quotes, got %v", ids(got))
+	}
+
+	// with gaps in the ids the two stores still number the
+	// matches the same way
+	for _, store := range []QuoteStore{quotes, memory} {
+		for _, id := range []int64{2, 4} {
+			err := store.Delete(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for seed := range int64(20) {
+		want, err := quotes.GetRandom(ctx, QuoteSearch{}, 2, seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := memory.GetRandom(ctx, QuoteSearch{}, 2, seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(ids(got), ids(want)) {
+			t.Errorf("seed %d: expected the memory store to pick %v, got %v", seed, ids(want), ids(got))
+		}
+	}
+}
+
+func TestQuoteModelImport(t *testing.T) {
+	db := newTestDB(t)
+	quotes := QuoteModel{DB: db}
+	ctx := context.Background()
+
+	err := quotes.Insert(ctx, &Quotes{Content: "Know thyself", Author: "Socrates"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := []ImportRow{
+		{Line: 2, Quote: &Quotes{Content: "Know thyself", Author: "Socrates"}},
+		{Line: 3, Quote: &Quotes{Content: "Carpe diem", Author: " Horace ", Tags: []string{"time"}}},
+		{Line: 4, Quote: &Quotes{Content: "Carpe diem", Author: "Horace"}},
+		{Line: 5, Quote: &Quotes{Content: "Memento mori", Author: "Seneca"}},
+		// the spaces around the content don't make it another quote
+		{Line: 6, Quote: &Quotes{Content: " Know thyself\n", Author: "Socrates"}},
+		{Line: 7, Quote: &Quotes{Content: "Carpe diem ", Author: "Horace"}},
+		{Line: 8, Quote: &Quotes{Content: "Amor fati", Author: "Nietzsche"}},
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		result, err := quotes.Import(ctx, rows, dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Inserted != 3 || !slices.Equal(result.Duplicates, []int{2, 4, 6, 7}) {
+			t.Errorf("dry run %v: unexpected result %+v", dryRun, result)
+		}
+	}
+
+	// the dry run took no ids, the quotes get the next ones in
+	// the order of their lines
+	for id, content := range map[int64]string{2: "Carpe diem", 3: "Memento mori", 4: "Amor fati"} {
+		quote, err := quotes.Get(ctx, id)
+		if err != nil {
+			t.Fatalf("expected %q to be quote %d: %v", content, id, err)
+		}
+		if quote.Content != content {
+			t.Errorf("expected quote %d to be %q, got %q", id, content, quote.Content)
+		}
+	}
+	quote, err := quotes.Get(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.Author != "Horace" || !slices.Equal(quote.Tags, []string{"time"}) {
+		t.Errorf("unexpected imported quote %+v", quote)
+	}
+}
+
+func TestUserModelRegister(t *testing.T) {
+	db := newTestDB(t)
+	users := UserModel{DB: db}
+	ctx := context.Background()
+
+	register := func(email string) (*User, *Token, error) {
+		user := &User{Name: "Ann", Email: email}
+		err := user.Password.Set("pa55word1234")
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := users.Register(ctx, user, []string{PermissionQuotesRead}, time.Hour)
+		return user, token, err
+	}
+
+	user, token, err := register("ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := users.GetForToken(ctx, ScopeActivation, token.Plaintext)
+	if err != nil || got.ID != user.ID {
+		t.Errorf("expected the activation token to belong to user %d, got %v (%v)", user.ID, got, err)
+	}
+	permissions, err := PermissionModel{DB: db}.GetAllForUser(ctx, user.ID)
+	if err != nil || !slices.Equal(permissions, Permissions{PermissionQuotesRead}) {
+		t.Errorf("expected quotes:read, got %v (%v)", permissions, err)
+	}
+
+	// nothing is left of a registration that failed
+	_, _, err = register("ANN@example.com")
+	if !errors.Is(err, ErrDuplicateEmail) {
+		t.Fatalf("expected ErrDuplicateEmail, got %v", err)
+	}
+	var tokens int
+	err = db.QueryRow(`SELECT COUNT(*) FROM tokens`).Scan(&tokens)
+	if err != nil || tokens != 1 {
+		t.Errorf("expected only the first token, got %d (%v)", tokens, err)
+	}
+}
@@ -0,0 +1,141 @@
+// Filename: internal/data/random.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand/v2"
+
+	"github.com/lib/pq"
+)
+
+// randomPositions picks count distinct positions in [0, total), every
+// one as likely as any other, in the order they were drawn. It is a
+// Fisher-Yates shuffle that stops after count steps, the swapped
+// positions are kept in a map so a big total costs nothing. The same
+// seed always gives the same positions, which keeps our tests
+// reproducible
+func randomPositions(total int64, count int, seed int64) []int64 {
+	rng := rand.New(rand.NewPCG(uint64(seed), uint64(seed)>>1))
+
+	n := min(int64(count), total)
+	swapped := make(map[int64]int64, n)
+	at := func(i int64) int64 {
+		if position, found := swapped[i]; found {
+			return position
+		}
+		return i
+	}
+
+	positions := make([]int64, n)
+	for i := range n {
+		j := i + rng.Int64N(total-i)
+		positions[i] = at(j)
+		swapped[j] = at(i)
+	}
+	return positions
+}
+
+// GetRandom returns up to count random quotes matching the search, each
+// match as likely as any other. We count the matches, draw positions
+// among them and number the matches by id to find the quotes at those
+// positions, all in one snapshot so a quote added or deleted in between
+// can't shift them. That is one pass over the matches instead of the
+// sort ORDER BY random() does. The full rows, tags included, are only
+// read for the quotes we picked
+func (q QuoteModel) GetRandom(ctx context.Context, search QuoteSearch, count int, seed int64) ([]*Quotes, error) {
+	ctx, cancel := q.queryContext(ctx)
+	defer cancel()
+
+	tx, err := q.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	where, args := search.where()
+
+	var total int64
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+quoteTables+where, args...).Scan(&total)
+	if err != nil {
+		return nil, err
+	}
+	positions := randomPositions(total, count, seed)
+	if len(positions) == 0 {
+		return []*Quotes{}, nil
+	}
+
+	query := fmt.Sprintf(`
+        SELECT numbered.position, numbered.id
+        FROM (
+            SELECT quotes.id, row_number() OVER (ORDER BY quotes.id) - 1 AS position
+            FROM %s
+            %s
+        ) AS numbered
+        WHERE numbered.position = ANY($%d)
+      `, quoteTables, where, len(args)+1)
+	rows, err := tx.QueryContext(ctx, query, append(args, pq.Array(positions))...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	atPosition := make(map[int64]int64, len(positions))
+	for rows.Next() {
+		var position, id int64
+		err := rows.Scan(&position, &id)
+		if err != nil {
+			return nil, err
+		}
+		atPosition[position] = id
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, 0, len(positions))
+	for _, position := range positions {
+		ids = append(ids, atPosition[position])
+	}
+
+	query = fmt.Sprintf(`
+        SELECT %s
+        FROM %s
+        WHERE quotes.id = ANY($1)
+      `, quoteColumns, quoteTables)
+	rows, err = tx.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byID := make(map[int64]*Quotes, len(ids))
+	for rows.Next() {
+		var quote Quotes
+		err := rows.Scan(quote.scanTargets()...)
+		if err != nil {
+			return nil, err
+		}
+		byID[quote.ID] = &quote
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return inPickedOrder(ids, byID), nil
+}
+
+// return the quotes in the order their ids were picked
+func inPickedOrder(ids []int64, byID map[int64]*Quotes) []*Quotes {
+	quotes := make([]*Quotes, 0, len(ids))
+	for _, id := range ids {
+		quote, found := byID[id]
+		if found {
+			quotes = append(quotes, quote)
+		}
+	}
+	return quotes
+}
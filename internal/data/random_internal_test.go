@@ -0,0 +1,63 @@
+// Filename: internal/data/random_internal_test.go
+
+package data
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGetRandomSpread(t *testing.T) {
+	quotes := NewMemoryQuoteStore()
+	ctx := context.Background()
+
+	// the quotes left have big gaps before some of them
+	for range 60 {
+		err := quotes.Insert(ctx, &Quotes{Content: "Be still", Author: "Seneca"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	kept := map[int64]bool{1: true, 2: true, 3: true, 40: true, 41: true, 60: true}
+	for id := int64(1); id <= 60; id++ {
+		if !kept[id] {
+			err := quotes.Delete(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	const draws = 6000
+	for _, count := range []int{1, 3} {
+		picked := make(map[int64]int)
+		for seed := range int64(draws) {
+			got, err := quotes.GetRandom(ctx, QuoteSearch{}, count, seed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != count {
+				t.Fatalf("expected %d quotes, got %d", count, len(got))
+			}
+			for _, quote := range got {
+				picked[quote.ID]++
+			}
+		}
+
+		// every quote should come up about as often as the others
+		want := draws * count / len(kept)
+		for id := range kept {
+			if picked[id] < want*8/10 || picked[id] > want*12/10 {
+				t.Errorf("count %d: expected quote %d about %d times, got %d", count, id, want, picked[id])
+			}
+		}
+	}
+
+	got, err := quotes.GetRandom(ctx, QuoteSearch{}, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(kept) {
+		t.Errorf("expected all %d quotes, got %d", len(kept), len(got))
+	}
+}
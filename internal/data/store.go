@@ -0,0 +1,71 @@
+// Filename: internal/data/store.go
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// QuoteStore is everything the handlers need from wherever the quotes
+// are kept. QuoteModel keeps them in PostgreSQL while MemoryQuoteStore
+// keeps them in a map, which is handy for demos and tests
+type QuoteStore interface {
+	Insert(ctx context.Context, quote *Quotes) error
+	Get(ctx context.Context, id int64) (*Quotes, error)
+	Update(ctx context.Context, quote *Quotes) error
+	Delete(ctx context.Context, id int64) error
+	GetAll(ctx context.Context, search QuoteSearch, filters Filters) ([]*Quotes, Metadata, error)
+	Export(ctx context.Context, search QuoteSearch, filters Filters, fn func(quote *Quotes) error) error
+	GetRandom(ctx context.Context, search QuoteSearch, count int, seed int64) ([]*Quotes, error)
+	ListTags(ctx context.Context) ([]*Tag, error)
+	GetDaily(ctx context.Context, day time.Time, window int) (*DailyQuote, error)
+	PinDaily(ctx context.Context, day time.Time, quoteID int64) error
+}
+
+// UserStore keeps the user accounts
+type UserStore interface {
+	Insert(ctx context.Context, user *User) error
+	Register(ctx context.Context, user *User, permissions []string, activationTTL time.Duration) (*Token, error)
+	Get(ctx context.Context, id int64) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	Update(ctx context.Context, user *User) error
+	GetForToken(ctx context.Context, tokenScope string, tokenPlaintext string) (*User, error)
+}
+
+// TokenStore keeps the (hashed) tokens handed out to the users
+type TokenStore interface {
+	New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error)
+	Insert(ctx context.Context, token *Token) error
+	Delete(ctx context.Context, scope string, tokenPlaintext string) error
+	DeleteAllForUser(ctx context.Context, scope string, userID int64) error
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+// PermissionStore keeps what each user is allowed to do
+type PermissionStore interface {
+	GetAllForUser(ctx context.Context, userID int64) (Permissions, error)
+	AddForUser(ctx context.Context, userID int64, codes ...string) error
+}
+
+// APIKeyStore keeps the (hashed) API keys of the machine clients
+type APIKeyStore interface {
+	Insert(ctx context.Context, key *APIKey) error
+	GetByPlaintext(ctx context.Context, plaintext string) (*APIKey, error)
+	GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error)
+	Delete(ctx context.Context, id int64, userID int64) error
+}
+
+// make sure the implementations keep up with the interfaces
+var (
+	_ QuoteStore = QuoteModel{}
+	_ QuoteStore = (*MemoryQuoteStore)(nil)
+
+	_ UserStore       = UserModel{}
+	_ TokenStore      = TokenModel{}
+	_ PermissionStore = PermissionModel{}
+	_ APIKeyStore     = APIKeyModel{}
+	_ UserStore       = memoryUsers{}
+	_ TokenStore      = memoryTokens{}
+	_ PermissionStore = memoryPermissions{}
+	_ APIKeyStore     = memoryAPIKeys{}
+)
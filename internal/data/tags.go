@@ -0,0 +1,108 @@
+// Filename: internal/data/tags.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"strings"
+
+	"github.com/Lee26Ed/qod/internal/validator"
+	"github.com/lib/pq"
+)
+
+// Tag is one tag and how many quotes use it
+type Tag struct {
+	Name       string `json:"name"`
+	QuoteCount int    `json:"quote_count"`
+}
+
+// the tags of a quote as a column of the SELECTs on the quotes table.
+// Scan it with pq.Array(&quote.Tags)
+const quoteTagsColumn = `
+        COALESCE((
+            SELECT array_agg(t.name ORDER BY t.name)
+            FROM quotes_tags qt
+            INNER JOIN tags t ON t.id = qt.tag_id
+            WHERE qt.quote_id = quotes.id
+        ), '{}') AS tags`
+
+// NormalizeTags lowercases and trims the tags and drops repeated ones
+// so "Life" and " life" end up being the same tag
+func NormalizeTags(tags []string) []string {
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
+}
+
+// Check the (normalized) tags of a quote
+func ValidateTags(v *validator.Validator, tags []string) {
+	v.Check(len(tags) <= 10, "tags", "must not contain more than 10 tags")
+	for _, tag := range tags {
+		v.Check(tag != "", "tags", "must not contain empty tags")
+		v.Check(len(tag) <= 30, "tags", "must not contain tags more than 30 bytes long")
+	}
+}
+
+// replace the tags of a quote, creating the tags we have not seen before.
+// Runs inside the transaction that writes the quote
+func setQuoteTags(ctx context.Context, tx *sql.Tx, quoteID int64, tags []string) error {
+	_, err := tx.ExecContext(ctx, `
+        INSERT INTO tags (name)
+        SELECT unnest($1::text[])
+        ON CONFLICT (name) DO NOTHING
+      `, pq.Array(tags))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM quotes_tags WHERE quote_id = $1`, quoteID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO quotes_tags (quote_id, tag_id)
+        SELECT $1, id FROM tags WHERE name = ANY($2)
+      `, quoteID, pq.Array(tags))
+	return err
+}
+
+// ListTags returns the tags in use, the most used first
+func (q QuoteModel) ListTags(ctx context.Context) ([]*Tag, error) {
+	query := `
+        SELECT t.name, COUNT(qt.quote_id)
+        FROM tags t
+        INNER JOIN quotes_tags qt ON qt.tag_id = t.id
+        GROUP BY t.name
+        ORDER BY COUNT(qt.quote_id) DESC, t.name ASC
+      `
+	ctx, cancel := q.queryContext(ctx)
+	defer cancel()
+
+	rows, err := q.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []*Tag{}
+	for rows.Next() {
+		var tag Tag
+		err := rows.Scan(&tag.Name, &tag.QuoteCount)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, &tag)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
@@ -0,0 +1,118 @@
+// Filename: internal/data/tokens.go
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"time"
+
+	"github.com/Lee26Ed/qod/internal/validator"
+)
+
+// what a token may be used for
+const (
+	ScopeAuthentication = "authentication"
+	ScopeActivation     = "activation"
+	ScopePasswordReset  = "password-reset"
+)
+
+// Token is handed to the client once, we only keep its SHA-256 hash
+type Token struct {
+	Plaintext string    `json:"token"`
+	Hash      []byte    `json:"-"`
+	UserID    int64     `json:"-"`
+	Expiry    time.Time `json:"expiry"`
+	Scope     string    `json:"-"`
+}
+
+// rand.Text() gives 128 random bits as 26 base32 characters
+func generateToken(userID int64, ttl time.Duration, scope string) *Token {
+	token := &Token{
+		Plaintext: rand.Text(),
+		UserID:    userID,
+		Expiry:    time.Now().Add(ttl),
+		Scope:     scope,
+	}
+	token.Hash = hashToken(token.Plaintext)
+	return token
+}
+
+// the hash we store for a plaintext token
+func hashToken(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
+func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
+	v.Check(tokenPlaintext != "", "token", "must be provided")
+	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+}
+
+// A TokenModel expects a connection pool
+type TokenModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// New creates a token for the user and stores its hash
+func (m TokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token := generateToken(userID, ttl, scope)
+	err := m.Insert(ctx, token)
+	return token, err
+}
+
+// Insert the hash of a token
+func (m TokenModel) Insert(ctx context.Context, token *Token) error {
+	query := `
+        INSERT INTO tokens (hash, user_id, expiry, scope)
+        VALUES ($1, $2, $3, $4)
+      `
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Delete one token, used to log out
+func (m TokenModel) Delete(ctx context.Context, scope string, tokenPlaintext string) error {
+	query := `
+        DELETE FROM tokens
+        WHERE hash = $1 AND scope = $2
+      `
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, hashToken(tokenPlaintext), scope)
+	return err
+}
+
+// DeleteAllForUser revokes every token of a user with the given scope
+func (m TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	query := `
+        DELETE FROM tokens
+        WHERE scope = $1 AND user_id = $2
+      `
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, scope, userID)
+	return err
+}
+
+// DeleteExpired removes the tokens that can't be used any more
+// and reports how many there were
+func (m TokenModel) DeleteExpired(ctx context.Context) (int64, error) {
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM tokens WHERE expiry < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
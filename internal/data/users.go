@@ -0,0 +1,324 @@
+// Filename: internal/data/users.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Lee26Ed/qod/internal/validator"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// another account already uses the email address
+var ErrDuplicateEmail = errors.New("duplicate email")
+
+// User is a person with an account. The password hash never leaves the API
+type User struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Password  password  `json:"-"`
+	Activated bool      `json:"activated"`
+	Version   int       `json:"-"`
+}
+
+// We keep the plaintext only long enough to validate it. A nil
+// plaintext means the password was loaded from the database
+type password struct {
+	plaintext *string
+	hash      []byte
+}
+
+// Set hashes the plaintext password with bcrypt
+func (p *password) Set(plaintextPassword string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	if err != nil {
+		return err
+	}
+
+	p.plaintext = &plaintextPassword
+	p.hash = hash
+	return nil
+}
+
+// Matches checks a plaintext password against the stored hash
+func (p *password) Matches(plaintextPassword string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// the hash of a password nobody has, made with the same cost as the
+// real ones. Checking against it when there is no such user makes a
+// login with an unknown email take as long as one with a wrong password
+var dummyPassword = password{hash: []byte("$2a$12$kptWnNnuB1Of1MYEOujuv.6bEWCXW0jRJxdgZv7PaW/lAnc0YDGXW")}
+
+// CompareDummyPassword spends the time of a password check on a user
+// who doesn't exist. The result doesn't matter, it never matches
+func CompareDummyPassword(plaintextPassword string) {
+	dummyPassword.Matches(plaintextPassword)
+}
+
+func ValidateEmail(v *validator.Validator, email string) {
+	v.Check(email != "", "email", "must be provided")
+	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
+}
+
+// bcrypt only looks at the first 72 bytes of a password
+func ValidatePasswordPlaintext(v *validator.Validator, password string) {
+	v.Check(password != "", "password", "must be provided")
+	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
+	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+}
+
+func ValidateUser(v *validator.Validator, user *User) {
+	v.Check(user.Name != "", "name", "must be provided")
+	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	ValidateEmail(v, user.Email)
+
+	// a new user is checked before the password is hashed, so the
+	// caller validates the plaintext it is about to set
+	if user.Password.plaintext != nil {
+		ValidatePasswordPlaintext(v, *user.Password.plaintext)
+	}
+}
+
+// A UserModel expects a connection pool
+type UserModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Insert a new user. The email column is citext so
+// "Ann@example.com" and "ann@example.com" are the same account
+func (m UserModel) Insert(ctx context.Context, user *User) error {
+	query := `
+        INSERT INTO users (name, email, password_hash, activated)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id, created_at, version
+      `
+	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err, "users_email_key"):
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Register adds a new user together with their permissions and the
+// token to activate the account. It is all one transaction: a user is
+// never left without the permissions or a way to activate
+func (m UserModel) Register(ctx context.Context, user *User, permissions []string, activationTTL time.Duration) (*Token, error) {
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+        INSERT INTO users (name, email, password_hash, activated)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id, created_at, version
+      `
+	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err, "users_email_key"):
+			return nil, ErrDuplicateEmail
+		default:
+			return nil, err
+		}
+	}
+
+	query = `
+        INSERT INTO users_permissions (user_id, permission_id)
+        SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+      `
+	_, err = tx.ExecContext(ctx, query, user.ID, pq.Array(permissions))
+	if err != nil {
+		return nil, err
+	}
+
+	token := generateToken(user.ID, activationTTL, ScopeActivation)
+	query = `
+        INSERT INTO tokens (hash, user_id, expiry, scope)
+        VALUES ($1, $2, $3, $4)
+      `
+	_, err = tx.ExecContext(ctx, query, token.Hash, token.UserID, token.Expiry, token.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, tx.Commit()
+}
+
+// Get a specific user
+func (m UserModel) Get(ctx context.Context, id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+        SELECT id, created_at, name, email, password_hash, activated, version
+        FROM users
+        WHERE id = $1
+      `
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var user User
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// Get the user with the given email address
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	query := `
+        SELECT id, created_at, name, email, password_hash, activated, version
+        FROM users
+        WHERE email = $1
+      `
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var user User
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// Update a user if it still has the version we read
+func (m UserModel) Update(ctx context.Context, user *User) error {
+	query := `
+        UPDATE users
+        SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
+        WHERE id = $5 AND version = $6
+        RETURNING version
+      `
+	args := []any{
+		user.Name,
+		user.Email,
+		user.Password.hash,
+		user.Activated,
+		user.ID,
+		user.Version,
+	}
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err, "users_email_key"):
+			return ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// AnonymousUser stands in for the caller when no credentials were sent
+var AnonymousUser = &User{}
+
+// IsAnonymous reports whether the user is the AnonymousUser
+func (u *User) IsAnonymous() bool {
+	return u == AnonymousUser
+}
+
+// GetForToken returns the owner of a token that has
+// the given scope and has not expired yet
+func (m UserModel) GetForToken(ctx context.Context, tokenScope string, tokenPlaintext string) (*User, error) {
+	query := `
+        SELECT users.id, users.created_at, users.name, users.email,
+               users.password_hash, users.activated, users.version
+        FROM users
+        INNER JOIN tokens ON tokens.user_id = users.id
+        WHERE tokens.hash = $1
+        AND tokens.scope = $2
+        AND tokens.expiry > $3
+      `
+	args := []any{hashToken(tokenPlaintext), tokenScope, time.Now()}
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var user User
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
@@ -0,0 +1,30 @@
+// Filename: internal/data/users_internal_test.go
+
+package data
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestDummyPassword(t *testing.T) {
+	// a broken hash would fail at once and give the unknown emails away
+	cost, err := bcrypt.Cost(dummyPassword.hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var real password
+	err = real.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := bcrypt.Cost(real.hash); cost != want {
+		t.Errorf("expected the cost of the real hashes (%d), got %d", want, cost)
+	}
+
+	match, err := dummyPassword.Matches("not the password of any user")
+	if err != nil || !match {
+		t.Errorf("expected the dummy hash to check out, got %v (%v)", match, err)
+	}
+}
@@ -0,0 +1,38 @@
+// Filename: internal/mailer/file.go
+package mailer
+
+import (
+	"os"
+	"time"
+)
+
+// FileTransport keeps every message as an .eml file in Dir instead of
+// sending it. Handy while developing: open the file with any mail client
+type FileTransport struct {
+	Dir string
+}
+
+func (t FileTransport) Send(msg *Message) error {
+	email, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(t.Dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	// the timestamp keeps the files in the order they were sent
+	f, err := os.CreateTemp(t.Dir, time.Now().UTC().Format("20060102T150405")+"-*.eml")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(email)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,142 @@
+// Filename: internal/mailer/mailer.go
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	ht "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// the email templates are compiled into the binary. Each file defines
+// a "subject", a "plainBody" and an "htmlBody" template
+//
+//go:embed "templates"
+var templateFS embed.FS
+
+// Message is one email ready to be handed to a Transport
+type Message struct {
+	From      string
+	To        string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
+// Transport delivers the messages. Swap it to send through
+// an SMTP server or to keep the emails on disk while developing
+type Transport interface {
+	Send(msg *Message) error
+}
+
+// Mailer renders the templates and hands the messages to its transport
+type Mailer struct {
+	transport Transport
+	sender    string
+}
+
+// New returns a Mailer sending from the sender address,
+// e.g. "QOD <no-reply@qod.example>"
+func New(transport Transport, sender string) *Mailer {
+	return &Mailer{
+		transport: transport,
+		sender:    sender,
+	}
+}
+
+// Send renders the template file with the data and emails it to the recipient
+func (m *Mailer) Send(recipient string, templateFile string, data any) error {
+	msg := &Message{
+		From: m.sender,
+		To:   recipient,
+	}
+
+	// the subject and the plain text body are not HTML escaped
+	textTmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return err
+	}
+
+	var subject, plainBody bytes.Buffer
+	err = textTmpl.ExecuteTemplate(&subject, "subject", data)
+	if err != nil {
+		return err
+	}
+	err = textTmpl.ExecuteTemplate(&plainBody, "plainBody", data)
+	if err != nil {
+		return err
+	}
+
+	htmlTmpl, err := ht.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return err
+	}
+
+	var htmlBody bytes.Buffer
+	err = htmlTmpl.ExecuteTemplate(&htmlBody, "htmlBody", data)
+	if err != nil {
+		return err
+	}
+
+	msg.Subject = strings.TrimSpace(subject.String())
+	msg.PlainBody = plainBody.String()
+	msg.HTMLBody = htmlBody.String()
+
+	return m.transport.Send(msg)
+}
+
+// Bytes formats the message as a multipart/alternative email (RFC 5322)
+// with the plain text part first, so clients that can show HTML prefer it
+func (msg *Message) Bytes() ([]byte, error) {
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", msg.PlainBody},
+		{"text/html; charset=utf-8", msg.HTMLBody},
+	} {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Type", part.contentType)
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+
+		w, err := parts.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		_, err = qp.Write([]byte(part.content))
+		if err != nil {
+			return nil, err
+		}
+		err = qp.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := parts.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var email bytes.Buffer
+	fmt.Fprintf(&email, "From: %s\r\n", msg.From)
+	fmt.Fprintf(&email, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&email, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&email, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&email, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&email, "Content-Type: multipart/alternative; boundary=%q\r\n", parts.Boundary())
+	fmt.Fprintf(&email, "\r\n")
+	email.Write(body.Bytes())
+
+	return email.Bytes(), nil
+}
@@ -0,0 +1,125 @@
+// Filename: internal/mailer/mailer_internal_test.go
+
+package mailer
+
+import (
+	"bufio"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// a bare bones SMTP server that accepts one message and hands back
+// the envelope and the DATA it received
+func startSMTPStandIn(t *testing.T) (host string, port int, received <-chan []string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	lines := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		tp := textproto.NewConn(conn)
+		var got []string
+		tp.PrintfLine("220 localhost stand-in")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			command := strings.ToUpper(strings.Fields(line + " ")[0])
+			switch command {
+			case "EHLO", "HELO":
+				tp.PrintfLine("250 localhost")
+			case "MAIL", "RCPT":
+				got = append(got, line)
+				tp.PrintfLine("250 OK")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				data, err := tp.ReadDotLines()
+				if err != nil {
+					return
+				}
+				got = append(got, data...)
+				tp.PrintfLine("250 OK")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				lines <- got
+				return
+			default:
+				tp.PrintfLine("502 not implemented")
+			}
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, lines
+}
+
+func TestSendWithSMTP(t *testing.T) {
+	host, port, received := startSMTPStandIn(t)
+
+	m := New(SMTPTransport{Host: host, Port: port}, "QOD <no-reply@qod.example>")
+	err := m.Send("ann@example.com", "user_welcome.tmpl", map[string]any{
+		"name":            "Ann <3",
+		"userID":          7,
+		"activationToken": "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	email := strings.Join(<-received, "\n")
+	for _, want := range []string{
+		"MAIL FROM:<no-reply@qod.example>",
+		"RCPT TO:<ann@example.com>",
+		"Subject: Welcome to QOD!",
+		"Content-Type: multipart/alternative",
+		`{"token": "ABCDEFGHIJKLMNOPQRSTUVWXYZ"}`,
+		// the plain text is left alone, the HTML is escaped
+		"Hi Ann <3,",
+		"Hi Ann &lt;3,",
+	} {
+		if !strings.Contains(email, want) {
+			t.Errorf("expected the email to contain %q\n%s", want, email)
+		}
+	}
+}
+
+func TestMessageBytes(t *testing.T) {
+	msg := &Message{
+		From:      "QOD <no-reply@qod.example>",
+		To:        "ann@example.com",
+		Subject:   "Café",
+		PlainBody: "plain",
+		HTMLBody:  "<p>html</p>",
+	}
+	email, err := msg.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every header line must end with CRLF
+	header, _, found := strings.Cut(string(email), "\r\n\r\n")
+	if !found {
+		t.Fatalf("expected a blank line after the headers\n%s", email)
+	}
+	r := textproto.NewReader(bufio.NewReader(strings.NewReader(header + "\r\n\r\n")))
+	fields, err := r.ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fields.Get("Subject"); got != "=?utf-8?q?Caf=C3=A9?=" {
+		t.Errorf("expected an encoded subject, got %q", got)
+	}
+}
@@ -0,0 +1,101 @@
+// Filename: internal/mailer/smtp.go
+package mailer
+
+import (
+	"crypto/tls"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// SMTPTransport sends the messages through an SMTP server. While
+// developing, point it at a local stand-in such as Mailpit or MailHog
+// (localhost:1025). The connection is upgraded with STARTTLS whenever
+// the server offers it, and we only log in when a Username is set
+type SMTPTransport struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// the longest a whole delivery may take, 10 seconds when zero
+	Timeout time.Duration
+}
+
+func (t SMTPTransport) Send(msg *Message) error {
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return err
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return err
+	}
+	email, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	timeout := t.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(t.Host, strconv.Itoa(t.Port)), timeout)
+	if err != nil {
+		return err
+	}
+	err = conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, t.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(&tls.Config{ServerName: t.Host})
+		if err != nil {
+			return err
+		}
+	}
+
+	// smtp.PlainAuth refuses to send the password over a plain
+	// connection unless the server is on localhost
+	if t.Username != "" {
+		err = client.Auth(smtp.PlainAuth("", t.Username, t.Password, t.Host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = client.Mail(from.Address)
+	if err != nil {
+		return err
+	}
+	err = client.Rcpt(to.Address)
+	if err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(email)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
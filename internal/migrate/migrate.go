@@ -0,0 +1,322 @@
+// Filename: internal/migrate/migrate.go
+
+// Package migrate applies SQL migrations to PostgreSQL. The progress is
+// kept in the same schema_migrations table the migrate CLI uses so the
+// two can be run against the same database
+package migrate
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// the database is already at the requested version
+	ErrNoChange = errors.New("no change")
+	// the version matches none of the migrations
+	ErrUnknownVersion = errors.New("unknown migration version")
+)
+
+// DirtyError means a migration failed half way. Someone has to look at
+// the database, fix it by hand and then Force the right version
+type DirtyError struct {
+	Version uint
+}
+
+func (e DirtyError) Error() string {
+	return fmt.Sprintf("database is dirty at version %d, fix it and force the version", e.Version)
+}
+
+// every process running migrations takes the same advisory lock so two
+// replicas starting together don't apply the same migration twice
+const lockID = 4_281_913_276
+
+// Migration is one numbered change with the SQL to apply and revert it
+type Migration struct {
+	Version uint
+	Name    string
+	Up      string
+	Down    string
+}
+
+// the files are named like the migrate CLI creates them
+var fileRX = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Load reads the migrations at the top of fsys, sorted by version
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[uint]*Migration{}
+	hasUp := map[uint]bool{}
+	for _, entry := range entries {
+		parts := fileRX.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || parts == nil {
+			continue
+		}
+		number, err := strconv.ParseUint(parts[1], 10, 0)
+		if err != nil || number == 0 {
+			return nil, fmt.Errorf("%s: the version must be a number above 0", entry.Name())
+		}
+		version := uint(number)
+
+		contents, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: parts[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != parts[2] {
+			return nil, fmt.Errorf("%s: version %d is also used by %q", entry.Name(), version, migration.Name)
+		}
+		switch parts[3] {
+		case "up":
+			migration.Up = string(contents)
+			hasUp[version] = true
+		case "down":
+			migration.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for version, migration := range byVersion {
+		if !hasUp[version] {
+			return nil, fmt.Errorf("migration %d (%s) has no up file", version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return migrations, nil
+}
+
+// A Migrator applies the migrations to the database. Version 0 means
+// none of them are applied
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+	// optional, says which migrations are applied
+	Logger *slog.Logger
+}
+
+// New loads the migrations in fsys for the database
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: db, Migrations: migrations}, nil
+}
+
+// Latest is the version Up migrates to
+func (m *Migrator) Latest() uint {
+	if len(m.Migrations) == 0 {
+		return 0
+	}
+	return m.Migrations[len(m.Migrations)-1].Version
+}
+
+// Version is where the database is. A database that was never
+// migrated is at version 0
+func (m *Migrator) Version(ctx context.Context) (uint, bool, error) {
+	return version(ctx, m.DB)
+}
+
+// Up applies all the migrations that are not applied yet
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.Goto(ctx, m.Latest())
+}
+
+// Down reverts all the migrations
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.Goto(ctx, 0)
+}
+
+// Goto migrates up or down until the database is at the version
+func (m *Migrator) Goto(ctx context.Context, target uint) error {
+	if !m.known(target) {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, target)
+	}
+
+	return m.locked(ctx, func(conn *sql.Conn) error {
+		current, dirty, err := version(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return DirtyError{Version: current}
+		}
+		if current == target {
+			return ErrNoChange
+		}
+		// the database was migrated by a newer build than this one
+		if !m.known(current) {
+			return fmt.Errorf("%w: the database is at version %d", ErrUnknownVersion, current)
+		}
+
+		if current < target {
+			for _, migration := range m.Migrations {
+				if migration.Version <= current || migration.Version > target {
+					continue
+				}
+				err := m.run(ctx, conn, migration.Version, migration.Up, migration.Version, "up", migration.Name)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		for i := len(m.Migrations) - 1; i >= 0; i-- {
+			migration := m.Migrations[i]
+			if migration.Version > current || migration.Version <= target {
+				continue
+			}
+			var previous uint
+			if i > 0 {
+				previous = m.Migrations[i-1].Version
+			}
+			err := m.run(ctx, conn, migration.Version, migration.Down, previous, "down", migration.Name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Force records the version without running anything. It is how a
+// dirty database is marked as fixed
+func (m *Migrator) Force(ctx context.Context, target uint) error {
+	if !m.known(target) {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, target)
+	}
+	return m.locked(ctx, func(conn *sql.Conn) error {
+		return setVersion(ctx, conn, target, false)
+	})
+}
+
+// 0 and the versions of the migrations are the only valid versions
+func (m *Migrator) known(v uint) bool {
+	return v == 0 || slices.ContainsFunc(m.Migrations, func(migration Migration) bool {
+		return migration.Version == v
+	})
+}
+
+// the database stays marked dirty at the version being applied or
+// reverted until its SQL went through. The migrations don't run in a
+// transaction, some statements (CREATE INDEX CONCURRENTLY) can't
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, v uint, query string, next uint, direction string, name string) error {
+	if m.Logger != nil {
+		m.Logger.Info("running migration", "version", v, "name", name, "direction", direction)
+	}
+
+	err := setVersion(ctx, conn, v, true)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(query) != "" {
+		_, err = conn.ExecContext(ctx, query)
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) %s: %w", v, name, direction, err)
+		}
+	}
+	return setVersion(ctx, conn, next, false)
+}
+
+// run fn on a connection holding the migration lock. Waiting for the
+// lock is how a second replica waits for the first one to finish
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// the lock belongs to the session, a connection we could not
+		// unlock must not go back to the pool still holding it
+		_, err := conn.ExecContext(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, lockID)
+		if err != nil {
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, `
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version bigint NOT NULL PRIMARY KEY,
+            dirty boolean NOT NULL
+        )`)
+	if err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// what we need from a *sql.DB or a *sql.Conn
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func version(ctx context.Context, q querier) (uint, bool, error) {
+	var v int64
+	var dirty bool
+	err := q.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&v, &dirty)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, false, nil
+		// undefined_table, nothing was ever migrated
+		case errors.As(err, &pqErr) && pqErr.Code == "42P01":
+			return 0, false, nil
+		default:
+			return 0, false, err
+		}
+	}
+	return uint(v), dirty, nil
+}
+
+// the table holds a single row, none at all for version 0
+func setVersion(ctx context.Context, conn *sql.Conn, v uint, dirty bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+	if v != 0 {
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, $2)`, int64(v), dirty)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
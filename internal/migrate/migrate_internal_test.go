@@ -0,0 +1,93 @@
+// Filename: internal/migrate/migrate_internal_test.go
+
+package migrate
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/Lee26Ed/qod/migrations"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"000002_add_index.up.sql":     {Data: []byte("CREATE INDEX ...;")},
+		"000001_create_things.up.sql": {Data: []byte("CREATE TABLE things ();")},
+		"000001_create_things.down.sql": {
+			Data: []byte("DROP TABLE things;"),
+		},
+		"README.md": {Data: []byte("not a migration")},
+	}
+
+	got, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Version != 1 || got[1].Version != 2 {
+		t.Fatalf("expected versions 1 and 2 in order, got %+v", got)
+	}
+	if got[0].Name != "create_things" || got[0].Down != "DROP TABLE things;" {
+		t.Errorf("unexpected first migration %+v", got[0])
+	}
+	// a missing down file only means the migration can't be reverted
+	if got[1].Down != "" {
+		t.Errorf("expected no down SQL, got %q", got[1].Down)
+	}
+
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+		want string
+	}{
+		{
+			name: "two names for one version",
+			fsys: fstest.MapFS{
+				"000001_a.up.sql": {Data: []byte("SELECT 1;")},
+				"000001_b.up.sql": {Data: []byte("SELECT 1;")},
+			},
+			want: "also used by",
+		},
+		{
+			name: "down without up",
+			fsys: fstest.MapFS{"000001_a.down.sql": {Data: []byte("SELECT 1;")}},
+			want: "has no up file",
+		},
+		{
+			name: "version 0",
+			fsys: fstest.MapFS{"000000_a.up.sql": {Data: []byte("SELECT 1;")}},
+			want: "above 0",
+		},
+	}
+	for _, tt := range tests {
+		_, err := Load(tt.fsys)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+// the files shipped in the binary must be complete and numbered 1, 2, 3...
+func TestEmbeddedMigrations(t *testing.T) {
+	migrator, err := New(nil, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrator.Migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	for i, migration := range migrator.Migrations {
+		if migration.Version != uint(i+1) {
+			t.Errorf("expected version %d, got %d (%s)", i+1, migration.Version, migration.Name)
+		}
+		if strings.TrimSpace(migration.Down) == "" {
+			t.Errorf("migration %d (%s) has no down SQL", migration.Version, migration.Name)
+		}
+	}
+	if migrator.Latest() != uint(len(migrator.Migrations)) {
+		t.Errorf("expected the latest version to be %d, got %d", len(migrator.Migrations), migrator.Latest())
+	}
+	if !migrator.known(0) || migrator.known(migrator.Latest()+1) {
+		t.Error("expected 0 to be known and the version after the latest not to be")
+	}
+}
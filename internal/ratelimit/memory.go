@@ -0,0 +1,73 @@
+// Filename: internal/ratelimit/memory.go
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Memory keeps the buckets in the process. Each replica of the
+// API counts on its own and a restart forgets everyone
+type Memory struct {
+	mu      sync.Mutex
+	clients map[string]*memoryClient
+}
+
+type memoryClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewMemory returns an empty in-memory limiter
+func NewMemory() *Memory {
+	return &Memory{
+		clients: make(map[string]*memoryClient),
+	}
+}
+
+func (m *Memory) Allow(ctx context.Context, key string, limit Rate) (Result, error) {
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	client, found := m.clients[key]
+	if !found {
+		client = &memoryClient{limiter: rate.NewLimiter(rate.Limit(limit.RPS), limit.Burst)}
+		m.clients[key] = client
+	}
+	client.lastSeen = time.Now()
+
+	// the rate changed since the last request, the tokens
+	// taken so far still count against the new one
+	if client.limiter.Limit() != rate.Limit(limit.RPS) || client.limiter.Burst() != limit.Burst {
+		client.limiter.SetLimitAt(client.lastSeen, rate.Limit(limit.RPS))
+		client.limiter.SetBurstAt(client.lastSeen, limit.Burst)
+	}
+
+	allowed := client.limiter.Allow()
+	return newResult(allowed, client.limiter.Tokens(), limit), nil
+}
+
+func (m *Memory) Prune(ctx context.Context, maxIdle time.Duration) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var pruned int64
+	for key, client := range m.clients {
+		if time.Since(client.lastSeen) > maxIdle {
+			delete(m.clients, key)
+			pruned++
+		}
+	}
+	return pruned, nil
+}
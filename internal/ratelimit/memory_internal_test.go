@@ -0,0 +1,78 @@
+// Filename: internal/ratelimit/memory_internal_test.go
+
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryAllow(t *testing.T) {
+	limiter := NewMemory()
+	limit := Rate{RPS: 0.5, Burst: 2}
+	ctx := t.Context()
+
+	for i, wantRemaining := range []int{1, 0} {
+		result, err := limiter.Allow(ctx, "ip:192.0.2.1", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed || result.Remaining != wantRemaining || result.Limit != 2 {
+			t.Errorf("request %d: expected allowed with %d remaining, got %+v", i+1, wantRemaining, result)
+		}
+	}
+
+	result, err := limiter.Allow(ctx, "ip:192.0.2.1", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed {
+		t.Error("expected the third request to be refused")
+	}
+	// one token comes back every 2 seconds
+	if result.RetryAfter <= 0 || result.RetryAfter > 2*time.Second {
+		t.Errorf("expected a retry within 2s, got %v", result.RetryAfter)
+	}
+
+	// the other clients have their own buckets
+	result, _ = limiter.Allow(ctx, "ip:192.0.2.2", limit)
+	if !result.Allowed {
+		t.Error("expected another key to be allowed")
+	}
+
+	pruned, err := limiter.Prune(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 2 {
+		t.Errorf("expected 2 buckets pruned, got %d", pruned)
+	}
+}
+
+func TestMemoryAllowNewRate(t *testing.T) {
+	limiter := NewMemory()
+	ctx := t.Context()
+
+	for range 3 {
+		limiter.Allow(ctx, "key:1", Rate{RPS: 0.01, Burst: 5})
+	}
+
+	// a bigger burst counts the requests already taken
+	result, err := limiter.Allow(ctx, "key:1", Rate{RPS: 0.01, Burst: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Allowed || result.Limit != 10 || result.Remaining != 1 {
+		t.Errorf("expected allowed with 1 of 10 remaining, got %+v", result)
+	}
+
+	// a smaller one caps what is left
+	limiter.Allow(ctx, "key:2", Rate{RPS: 0.01, Burst: 5})
+	result, err = limiter.Allow(ctx, "key:2", Rate{RPS: 0.01, Burst: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Allowed || result.Limit != 2 || result.Remaining != 1 {
+		t.Errorf("expected allowed with 1 of 2 remaining, got %+v", result)
+	}
+}
@@ -0,0 +1,71 @@
+// Filename: internal/ratelimit/postgres.go
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Postgres keeps the buckets in the rate_limit_buckets table so that
+// every replica of the API shares them and they survive restarts
+type Postgres struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Allow refills the bucket for the time since it was last used and
+// takes a token, all in one upsert so concurrent requests from the
+// replicas are serialized on the row. A refused request takes nothing;
+// the allowed column remembers the verdict so RETURNING can report it
+func (p Postgres) Allow(ctx context.Context, key string, limit Rate) (Result, error) {
+	query := `
+        INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at)
+        VALUES ($1, GREATEST($2::float8 - 1, 0), $2::float8 >= 1, NOW())
+        ON CONFLICT (key) DO UPDATE SET
+            tokens = LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at) * $3::float8)
+                   - CASE WHEN LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at) * $3::float8) >= 1
+                          THEN 1 ELSE 0 END,
+            allowed = LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at) * $3::float8) >= 1,
+            updated_at = NOW()
+        RETURNING tokens, allowed
+      `
+	ctx, cancel := p.queryContext(ctx)
+	defer cancel()
+
+	var tokens float64
+	var allowed bool
+	err := p.DB.QueryRowContext(ctx, query, key, limit.Burst, limit.RPS).Scan(&tokens, &allowed)
+	if err != nil {
+		return Result{}, err
+	}
+	return newResult(allowed, tokens, limit), nil
+}
+
+func (p Postgres) Prune(ctx context.Context, maxIdle time.Duration) (int64, error) {
+	ctx, cancel := p.queryContext(ctx)
+	defer cancel()
+
+	result, err := p.DB.ExecContext(ctx,
+		`DELETE FROM rate_limit_buckets WHERE updated_at < NOW() - $1 * INTERVAL '1 second'`,
+		maxIdle.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// every request waits on the limiter so it gets a tight timeout
+func (p Postgres) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// make sure both implementations keep up with the interface
+var (
+	_ Limiter = (*Memory)(nil)
+	_ Limiter = Postgres{}
+)
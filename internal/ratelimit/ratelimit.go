@@ -0,0 +1,61 @@
+// Filename: internal/ratelimit/ratelimit.go
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Rate is a token bucket: it holds up to Burst requests
+// and refills at RPS requests per second
+type Rate struct {
+	RPS   float64
+	Burst int
+}
+
+// Result is the verdict on one request and what is left of the quota
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// until the bucket is full again
+	Reset time.Duration
+	// until the next request is allowed, zero while some are left
+	RetryAfter time.Duration
+}
+
+// Limiter keeps one bucket per key (an IP address, an API key...)
+type Limiter interface {
+	// Allow takes a request from the bucket of key, creating it
+	// when it doesn't exist yet. The bucket goes by the rate of this
+	// request, even when an earlier one came with another
+	Allow(ctx context.Context, key string, rate Rate) (Result, error)
+	// Prune forgets the buckets that were not used for maxIdle
+	// and reports how many there were
+	Prune(ctx context.Context, maxIdle time.Duration) (int64, error)
+}
+
+// work out the quota from the tokens left in the bucket
+func newResult(allowed bool, tokens float64, rate Rate) Result {
+	result := Result{
+		Allowed:   allowed,
+		Limit:     rate.Burst,
+		Remaining: max(int(math.Floor(tokens)), 0),
+	}
+
+	// a bucket that never refills has no reset to report
+	if rate.RPS <= 0 {
+		return result
+	}
+
+	result.Reset = secondsToDuration((float64(rate.Burst) - tokens) / rate.RPS)
+	if tokens < 1 {
+		result.RetryAfter = secondsToDuration((1 - tokens) / rate.RPS)
+	}
+	return result
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(max(seconds, 0) * float64(time.Second))
+}
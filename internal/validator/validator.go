@@ -1,6 +1,9 @@
 package validator
 
-import "slices"
+import (
+	"regexp"
+	"slices"
+)
 
 type Validator struct {
 	Errors map[string]string
@@ -38,4 +41,13 @@ func (v *Validator) AddError(key string, message string) {
 
 func PermittedValue(value string, permittedValues ...string) bool {
 	return slices.Contains(permittedValues, value)
-}
\ No newline at end of file
+}
+
+// A regular expression for sanity checking email addresses
+// (the one recommended by the W3C for <input type="email">)
+var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
+// Check that a string value matches a regular expression
+func Matches(value string, rx *regexp.Regexp) bool {
+	return rx.MatchString(value)
+}
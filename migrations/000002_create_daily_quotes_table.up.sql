@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS daily_quotes (
+    day DATE PRIMARY KEY,
+    quote_id bigint NOT NULL REFERENCES quotes ON DELETE CASCADE,
+    pinned boolean NOT NULL DEFAULT false,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS daily_quotes_quote_id_idx ON daily_quotes (quote_id);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS tags (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS quotes_tags (
+    quote_id bigint NOT NULL REFERENCES quotes ON DELETE CASCADE,
+    tag_id bigint NOT NULL REFERENCES tags ON DELETE CASCADE,
+    PRIMARY KEY (quote_id, tag_id)
+);
+
+CREATE INDEX IF NOT EXISTS quotes_tags_tag_id_idx ON quotes_tags (tag_id);
@@ -0,0 +1,10 @@
+ALTER TABLE quotes ADD COLUMN IF NOT EXISTS author text;
+
+UPDATE quotes SET author = authors.name
+FROM authors
+WHERE authors.id = quotes.author_id;
+
+ALTER TABLE quotes ALTER COLUMN author SET NOT NULL;
+ALTER TABLE quotes DROP COLUMN IF EXISTS author_id;
+
+DROP TABLE IF EXISTS authors;
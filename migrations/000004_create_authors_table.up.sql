@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS authors (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL UNIQUE,
+    bio text NOT NULL DEFAULT '',
+    born date,
+    died date,
+    version integer NOT NULL DEFAULT 1,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- every distinct author text becomes an author
+INSERT INTO authors (name)
+SELECT DISTINCT btrim(author) FROM quotes
+ON CONFLICT (name) DO NOTHING;
+
+ALTER TABLE quotes ADD COLUMN IF NOT EXISTS author_id bigint REFERENCES authors ON DELETE RESTRICT;
+
+UPDATE quotes SET author_id = authors.id
+FROM authors
+WHERE authors.name = btrim(quotes.author);
+
+ALTER TABLE quotes ALTER COLUMN author_id SET NOT NULL;
+ALTER TABLE quotes DROP COLUMN IF EXISTS author;
+
+CREATE INDEX IF NOT EXISTS quotes_author_id_idx ON quotes (author_id);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS tokens (
+    hash bytea PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    scope text NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS tokens_expiry_idx ON tokens (expiry);
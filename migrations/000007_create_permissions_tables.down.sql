@@ -0,0 +1,4 @@
+ALTER TABLE quotes DROP COLUMN IF EXISTS created_by;
+
+DROP TABLE IF EXISTS users_permissions;
+DROP TABLE IF EXISTS permissions;
@@ -0,0 +1,4 @@
+-- when a quote last changed, for Last-Modified and If-Unmodified-Since
+ALTER TABLE quotes ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW();
+
+UPDATE quotes SET updated_at = created_at;
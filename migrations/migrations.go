@@ -0,0 +1,12 @@
+// Filename: migrations/migrations.go
+
+// Package migrations embeds the SQL migrations so the binary can
+// apply them without the files or the migrate CLI being around
+package migrations
+
+import "embed"
+
+// FS holds the NNNNNN_name.up.sql and NNNNNN_name.down.sql files
+//
+//go:embed *.sql
+var FS embed.FS